@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/HackerLoop/rotonde/shared"
+)
+
+// ACLRules lists the identifiers a module may define, send (actions and
+// events) and subscribe to. Identifiers are patterns, "*" matches any
+// sequence of characters, "/" and ":" included (eg. "LIGHT_*"), the other
+// characters only match themselves.
+type ACLRules struct {
+	Define    []string `json:"define"`
+	Send      []string `json:"send"`
+	Subscribe []string `json:"subscribe"`
+}
+
+// ACL maps module identities (as authenticated by the Authenticator) to
+// their rules, loaded from a JSON file of the form:
+//
+//	{
+//	  "modules": {
+//	    "light-module": {"define": ["LIGHT_*"], "send": ["LIGHT_*"], "subscribe": []},
+//	    "*": {"define": [], "send": ["LIGHT_*"], "subscribe": ["*"]}
+//	  }
+//	}
+//
+// The "*" entry applies to modules that have no entry of their own, including
+// unauthenticated connections (eg. HID devices). A module without any
+// matching entry is denied everything.
+type ACL struct {
+	Modules map[string]*ACLRules `json:"modules"`
+}
+
+// LoadACL reads the ACL file
+func LoadACL(filePath string) (*ACL, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	acl := new(ACL)
+	if err := json.Unmarshal(content, acl); err != nil {
+		return nil, fmt.Errorf("Could not parse %s: %s", filePath, err)
+	}
+	return acl, nil
+}
+
+func (acl *ACL) rulesFor(identity string) *ACLRules {
+	if rules, ok := acl.Modules[identity]; ok {
+		return rules
+	}
+	return acl.Modules["*"]
+}
+
+// Allows returns true if the module with the given identity may send this packet to the dispatcher
+func (acl *ACL) Allows(identity string, packet interface{}) bool {
+	rules := acl.rulesFor(identity)
+	if rules == nil {
+		return false
+	}
+
+	switch data := packet.(type) {
+	case rotonde.Event:
+		return matchesAny(rules.Send, data.Identifier)
+	case rotonde.Action:
+		return matchesAny(rules.Send, data.Identifier)
+	case rotonde.Subscription:
+		return matchesAny(rules.Subscribe, data.Identifier)
+	case rotonde.Unsubscription:
+		return true
+	case rotonde.Definition:
+		return matchesAny(rules.Define, data.Identifier)
+	case rotonde.UnDefinition:
+		return matchesAny(rules.Define, data.Identifier)
+	case rotonde.Batch:
+		for _, packet := range data.Packets {
+			if acl.Allows(identity, packet) == false {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchesAny(patterns []string, identifier string) bool {
+	for _, pattern := range patterns {
+		if matchesPattern(pattern, identifier) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPattern matches identifier against pattern, where "*" matches any
+// sequence of characters. Unlike path.Match, "*" also matches "/", and "?",
+// "[" and backslashes are not special, identifiers are free-form strings.
+func matchesPattern(pattern, identifier string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == identifier
+	}
+	// the first part is a prefix, the last one a suffix, the others appear in order in between
+	last := parts[len(parts)-1]
+	if strings.HasPrefix(identifier, parts[0]) == false || len(identifier) < len(parts[0])+len(last) {
+		return false
+	}
+	middle := identifier[len(parts[0]) : len(identifier)-len(last)]
+	for _, part := range parts[1 : len(parts)-1] {
+		index := strings.Index(middle, part)
+		if index < 0 {
+			return false
+		}
+		middle = middle[index+len(part):]
+	}
+	return strings.HasSuffix(identifier, last)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/HackerLoop/rotonde/shared"
+)
+
+func TestMatchesPattern(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		identifier string
+		matches    bool
+	}{
+		{"LIGHT_ON", "LIGHT_ON", true},
+		{"LIGHT_ON", "LIGHT_OFF", false},
+		{"*", "", true},
+		{"*", "kitchen:sensors/TEMPERATURE", true},
+		{"LIGHT_*", "LIGHT_ON", true},
+		{"LIGHT_*", "SWITCH_LIGHT_ON", false},
+		{"*_ON", "LIGHT_ON", true},
+		{"*_ON", "LIGHT_OFF", false},
+		{"kitchen:*", "kitchen:livingroom:LED", true},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "acb", false},
+		{"ab*ba", "aba", false}, // the prefix and suffix can't overlap
+		{"LED?", "LED?", true},
+		{"LED?", "LED1", false},
+		{"[a-z]", "[a-z]", true},
+		{"[a-z]", "a", false},
+		{`a\*`, `a\b`, true},
+	}
+	for _, test := range tests {
+		if matchesPattern(test.pattern, test.identifier) != test.matches {
+			t.Errorf("%q against %q: expected %v", test.pattern, test.identifier, test.matches)
+		}
+	}
+}
+
+func TestACLAllows(t *testing.T) {
+	acl := &ACL{Modules: map[string]*ACLRules{
+		"light-module": {Define: []string{"LIGHT_*"}, Send: []string{"LIGHT_*"}, Subscribe: []string{}},
+		"*":            {Define: []string{}, Send: []string{"LIGHT_*"}, Subscribe: []string{"*"}},
+	}}
+	restricted := &ACL{Modules: map[string]*ACLRules{
+		"light-module": {Define: []string{"LIGHT_*"}},
+	}}
+
+	tests := []struct {
+		name     string
+		acl      *ACL
+		identity string
+		packet   interface{}
+		allowed  bool
+	}{
+		{"define", acl, "light-module", rotonde.Definition{Identifier: "LIGHT_ON", Type: "action"}, true},
+		{"define other", acl, "light-module", rotonde.Definition{Identifier: "DOOR_OPEN", Type: "action"}, false},
+		{"undefine", acl, "light-module", rotonde.UnDefinition{Identifier: "LIGHT_ON", Type: "action"}, true},
+		{"send action", acl, "light-module", rotonde.Action{Identifier: "LIGHT_ON"}, true},
+		{"send event", acl, "light-module", rotonde.Event{Identifier: "DOOR_OPEN"}, false},
+		{"subscribe", acl, "light-module", rotonde.Subscription{Identifier: "LIGHT_ON"}, false},
+		{"unsubscribe", acl, "light-module", rotonde.Unsubscription{Identifier: "LIGHT_ON"}, true},
+		{"fallback define", acl, "", rotonde.Definition{Identifier: "LIGHT_ON", Type: "event"}, false},
+		{"fallback send", acl, "unknown", rotonde.Action{Identifier: "LIGHT_OFF"}, true},
+		{"fallback subscribe", acl, "", rotonde.Subscription{Identifier: "kitchen:sensors/TEMPERATURE"}, true},
+		{"unknown identity without fallback", restricted, "unknown", rotonde.Subscription{Identifier: "LIGHT_ON"}, false},
+		{"unknown identity unsubscribe", restricted, "unknown", rotonde.Unsubscription{Identifier: "LIGHT_ON"}, false},
+		{"batch", acl, "light-module", rotonde.Batch{Packets: []interface{}{
+			rotonde.Action{Identifier: "LIGHT_ON"},
+			rotonde.Event{Identifier: "LIGHT_CHANGED"},
+		}}, true},
+		{"batch with a denied packet", acl, "light-module", rotonde.Batch{Packets: []interface{}{
+			rotonde.Action{Identifier: "LIGHT_ON"},
+			rotonde.Action{Identifier: "DOOR_OPEN"},
+		}}, false},
+		{"hello", restricted, "light-module", rotonde.Hello{Version: rotonde.ProtocolVersion}, true},
+	}
+	for _, test := range tests {
+		if test.acl.Allows(test.identity, test.packet) != test.allowed {
+			t.Errorf("%s: expected allowed %v", test.name, test.allowed)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Authenticator maps static tokens to module identities, tokens are loaded
+// from a JSON file of the form:
+//
+//	{
+//	  "tokens": {
+//	    "secret token": "module identity"
+//	  }
+//	}
+type Authenticator struct {
+	Tokens map[string]string `json:"tokens"`
+}
+
+// LoadAuthenticator reads the tokens file
+func LoadAuthenticator(path string) (*Authenticator, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	authenticator := new(Authenticator)
+	if err := json.Unmarshal(content, authenticator); err != nil {
+		return nil, fmt.Errorf("Could not parse %s: %s", path, err)
+	}
+	if len(authenticator.Tokens) == 0 {
+		return nil, fmt.Errorf("No tokens found in %s", path)
+	}
+	return authenticator, nil
+}
+
+// Authenticate returns the identity associated with a token
+func (authenticator *Authenticator) Authenticate(token string) (string, error) {
+	if token == "" {
+		return "", fmt.Errorf("Missing authentication token")
+	}
+	identity, ok := authenticator.Tokens[token]
+	if ok == false {
+		return "", fmt.Errorf("Invalid authentication token")
+	}
+	return identity, nil
+}
+
+// tokenFromRequest looks for a token in the query string (token=) or in the
+// Authorization header (Bearer), returns an empty string if there is none.
+func tokenFromRequest(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	return ""
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HackerLoop/rotonde/shared"
+	"github.com/gorilla/websocket"
+)
+
+// startAuthServer serves the default websocket endpoint, with authentication
+func startAuthServer(t *testing.T) (*httptest.Server, string) {
+	authenticator := &Authenticator{Tokens: map[string]string{"secret": "light-module"}}
+	d := NewDispatcher()
+	go d.Start()
+	server := httptest.NewServer(websocketHandler(d, WebsocketOptions{Authenticator: authenticator}, DefaultEndpoints(authenticator)["/"]))
+	return server, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// expectAuthenticated checks that the dispatcher sends packets on the connection
+func expectAuthenticated(t *testing.T, name string, conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Errorf("%s: expected the connection to be authenticated, got %v", name, err)
+	}
+}
+
+// expectRejected checks that the connection is closed with a policy violation
+func expectRejected(t *testing.T, name string, conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	if websocket.IsCloseError(err, websocket.ClosePolicyViolation) == false {
+		t.Errorf("%s: expected a policy violation close, got %v", name, err)
+	}
+}
+
+func TestWebsocketAuthentication(t *testing.T) {
+	server, url := startAuthServer(t)
+	defer server.Close()
+
+	authPacket := func(token string) []byte {
+		jsonPacket, _ := rotonde.ToJSON(rotonde.Auth{Token: token})
+		return jsonPacket
+	}
+	tests := []struct {
+		name          string
+		query         string
+		header        http.Header
+		packet        []byte // first packet sent, when not nil
+		authenticated bool
+	}{
+		{"query string token", "?token=secret", nil, nil, true},
+		{"bearer header", "", http.Header{"Authorization": {"Bearer secret"}}, nil, true},
+		{"auth packet", "", nil, authPacket("secret"), true},
+		{"wrong query string token", "?token=wrong", nil, nil, false},
+		{"wrong bearer header", "", http.Header{"Authorization": {"Bearer wrong"}}, nil, false},
+		{"wrong auth packet", "", nil, authPacket("wrong"), false},
+		{"missing token", "", nil, authPacket(""), false},
+		{"other packet first", "", nil, []byte(`{"type": "sub", "payload": {"identifier": "LIGHT_ON"}}`), false},
+	}
+	for _, test := range tests {
+		conn, _, err := websocket.DefaultDialer.Dial(url+test.query, test.header)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if test.packet != nil {
+			conn.WriteMessage(websocket.TextMessage, test.packet)
+		}
+		if test.authenticated {
+			expectAuthenticated(t, test.name, conn)
+		} else {
+			expectRejected(t, test.name, conn)
+		}
+		conn.Close()
+	}
+}
+
+func TestWebsocketAuthTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { AuthTimeout = timeout }(AuthTimeout)
+	AuthTimeout = 100 * time.Millisecond
+
+	server, url := startAuthServer(t)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// nothing is sent
+	expectRejected(t, "auth timeout", conn)
+}
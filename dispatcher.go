@@ -10,6 +10,11 @@ import (
 // ChanQueueLength buffered channel length
 const ChanQueueLength = 100
 
+// presence events, dispatched when a connection that carries metadata (a
+// HID device for example) is added or removed from the dispatcher
+const PeerConnectedIdentifier = "ROTONDE_PEER_CONNECTED"
+const PeerDisconnectedIdentifier = "ROTONDE_PEER_DISCONNECTED"
+
 // Connection : basic interface representing a connection to the dispatcher
 type Connection struct {
 	actions rotonde.Definitions // actions that this connection can receive
@@ -17,8 +22,31 @@ type Connection struct {
 
 	subscriptions []string
 
+	// Metadata describes the peer behind the connection (eg. vendor, product
+	// and serial of a HID device), it is the data of the presence events.
+	// Connections without metadata don't trigger presence events.
+	Metadata rotonde.Object
+
+	// Identity is the authenticated identity of the module, empty when
+	// authentication is disabled
+	Identity string
+
+	// Version is the protocol version spoken by the peer, packets written to
+	// the connection are translated to this version. Defaults to the latest
+	// version, transports set it to the version negotiated with their peer.
+	Version int
+
+	// ForwardSubscriptions is set on the connections relaying events from
+	// elsewhere (federation links), they receive a sub packet when the first
+	// connection subscribes to one of the events they define, and an unsub
+	// packet when the last one unsubscribes, so they only relay the events
+	// someone listens to.
+	ForwardSubscriptions bool
+
 	InChan  chan interface{}
 	OutChan chan interface{}
+
+	added chan struct{} // closed once the dispatcher has added the connection
 }
 
 // NewConnection creates a new dispatcher connection
@@ -27,10 +55,19 @@ func NewConnection() *Connection {
 
 	connection.InChan = make(chan interface{}, ChanQueueLength)
 	connection.OutChan = make(chan interface{}, ChanQueueLength)
+	connection.added = make(chan struct{})
+	connection.Version = rotonde.ProtocolVersion
 
 	return connection
 }
 
+// Added returns a channel closed once the dispatcher has added the
+// connection, at this point all the available definitions have been written
+// to InChan.
+func (connection *Connection) Added() <-chan struct{} {
+	return connection.added
+}
+
 func (connection *Connection) Write(m interface{}) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -38,7 +75,9 @@ func (connection *Connection) Write(m interface{}) {
 			log.Warning(err)
 		}
 	}()
-	connection.InChan <- m
+	for _, packet := range rotonde.ForVersion(m, connection.Version) {
+		connection.InChan <- packet
+	}
 }
 
 func (connection *Connection) Close() {
@@ -85,6 +124,8 @@ type Dispatcher struct {
 	connections    []*Connection
 	cases          []reflect.SelectCase // cases for the select case of the main loop, the first element is for the connectionChan, the others are for the outChans of the connections
 	connectionChan chan *Connection     // connectionChan receives the new connections to add
+
+	acl *ACL // nil when access control is disabled
 }
 
 func NewDispatcher() *Dispatcher {
@@ -98,9 +139,23 @@ func NewDispatcher() *Dispatcher {
 	// first case is for the connectionChan
 	dispatcher.cases = append(dispatcher.cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(dispatcher.connectionChan)})
 
+	// presence events are provided by rotonde itself
+	for _, identifier := range []string{PeerConnectedIdentifier, PeerDisconnectedIdentifier} {
+		definition := &rotonde.Definition{Identifier: identifier, Type: "event"}
+		for _, field := range []string{"transport", "vendorId", "productId", "serial", "manufacturer", "product", "path"} {
+			definition.PushField(field, "string", "")
+		}
+		dispatcher.dispatchDefinition(-1, definition)
+	}
+
 	return dispatcher
 }
 
+// SetACL enables access control, has to be called before Start
+func (dispatcher *Dispatcher) SetACL(acl *ACL) {
+	dispatcher.acl = acl
+}
+
 func (dispatcher *Dispatcher) AddConnection(connection *Connection) {
 	dispatcher.connectionChan <- connection
 }
@@ -112,6 +167,23 @@ func (dispatcher *Dispatcher) addConnection(connection *Connection) {
 
 	dispatcher.connections = append(dispatcher.connections, connection)
 	dispatcher.cases = append(dispatcher.cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(connection.OutChan)})
+
+	if connection.Metadata != nil {
+		event := rotonde.Event{Identifier: PeerConnectedIdentifier, Data: connection.Metadata}
+		dispatcher.dispatchEvent(len(dispatcher.connections)-1, &event)
+	}
+}
+
+// sendConnectedPeers sends a presence event for each connected peer, this is
+// done when a connection subscribes to presence events, so it knows about
+// peers that connected before it.
+func (dispatcher *Dispatcher) sendConnectedPeers(to int) {
+	for i, connection := range dispatcher.connections {
+		if i == to || connection.Metadata == nil {
+			continue
+		}
+		dispatcher.connections[to].Write(rotonde.Event{Identifier: PeerConnectedIdentifier, Data: connection.Metadata})
+	}
 }
 
 func (dispatcher *Dispatcher) removeConnectionAt(index int) {
@@ -142,6 +214,34 @@ mainLoop:
 	}
 }
 
+// forwardSubscription writes a sub or unsub packet to the connections that
+// forward subscriptions and define the event, when the connection from is
+// the only one subscribed to it
+func (dispatcher *Dispatcher) forwardSubscription(from int, identifier string, packet interface{}) {
+	if dispatcher.isSubscribed(identifier, from) {
+		return
+	}
+	for i, connection := range dispatcher.connections {
+		if i == from || connection.ForwardSubscriptions == false {
+			continue
+		}
+		if _, err := connection.events.GetDefinitionForIdentifier(identifier); err == nil {
+			connection.Write(packet)
+		}
+	}
+}
+
+// isSubscribed returns true when a connection, other than the connection
+// except, is subscribed to the identifier
+func (dispatcher *Dispatcher) isSubscribed(identifier string, except int) bool {
+	for i, connection := range dispatcher.connections {
+		if i != except && connection.isSubscribed(identifier) {
+			return true
+		}
+	}
+	return false
+}
+
 func (dispatcher *Dispatcher) dispatchAction(from int, action *rotonde.Action) {
 	for i, connection := range dispatcher.connections {
 		if i == from {
@@ -218,6 +318,61 @@ func (dispatcher *Dispatcher) dispatchUnDefinition(from int, unDefinition *roton
 	}
 }
 
+// validate checks the data of an action or event against its definition, if
+// any, and returns it with the default values of the missing fields
+func (dispatcher *Dispatcher) validate(identifier string, data rotonde.Object) (rotonde.Object, error) {
+	definition, err := dispatcher.definitions.GetDefinitionForIdentifier(identifier)
+	if err != nil {
+		return data, nil
+	}
+	return definition.Validate(data)
+}
+
+// routeEvent validates the event and dispatches it to the subscribed connections
+func (dispatcher *Dispatcher) routeEvent(from int, event rotonde.Event) {
+	log.Info("Dispatching event ", event.Identifier)
+	validated, err := dispatcher.validate(event.Identifier, event.Data)
+	if err != nil {
+		log.Warning("Dropping invalid event ", err)
+		return
+	}
+	event.Data = validated
+	dispatcher.dispatchEvent(from, &event)
+}
+
+// routeAction validates the action and dispatches it to the connections that defined it
+func (dispatcher *Dispatcher) routeAction(from int, action rotonde.Action) {
+	log.Info("Dispatching action ", action.Identifier)
+	validated, err := dispatcher.validate(action.Identifier, action.Data)
+	if err != nil {
+		log.Warning("Dropping invalid action ", err)
+		return
+	}
+	action.Data = validated
+	dispatcher.dispatchAction(from, &action)
+}
+
+// negotiateVersion switches the connection to the version asked by its peer,
+// and answers with the version used. Definitions are sent again when the
+// version changes, as they were translated to the previous one.
+func (dispatcher *Dispatcher) negotiateVersion(from int, version int) {
+	connection := dispatcher.connections[from]
+	negotiated, err := rotonde.NegotiateVersion(version)
+	if err != nil {
+		log.Warning(err)
+		negotiated = connection.Version
+	}
+	log.Info("Using protocol version ", negotiated)
+	connection.Write(rotonde.Hello{Version: negotiated})
+	if negotiated == connection.Version {
+		return
+	}
+	connection.Version = negotiated
+	for _, def := range dispatcher.definitions {
+		connection.Write(*def)
+	}
+}
+
 func (dispatcher *Dispatcher) processChannels() {
 	chosen, value, ok := reflect.Select(dispatcher.cases)
 	chosen-- // there is an offset of 1, because the first element of dispatcher.cases is for the connection chan
@@ -228,22 +383,48 @@ func (dispatcher *Dispatcher) processChannels() {
 			unDefinition := rotonde.UnDefinition(*definition)
 			dispatcher.dispatchUnDefinition(chosen, &unDefinition)
 		}
+		for _, identifier := range dispatcher.connections[chosen].subscriptions {
+			dispatcher.forwardSubscription(chosen, identifier, rotonde.Unsubscription{Identifier: identifier})
+		}
+		if metadata := dispatcher.connections[chosen].Metadata; metadata != nil {
+			event := rotonde.Event{Identifier: PeerDisconnectedIdentifier, Data: metadata}
+			dispatcher.dispatchEvent(chosen, &event)
+		}
 		dispatcher.removeConnectionAt(chosen)
+	} else if chosen >= 0 && dispatcher.acl != nil && dispatcher.acl.Allows(dispatcher.connections[chosen].Identity, value.Interface()) == false {
+		log.Warningf("ACL denied %T from module \"%s\"", value.Interface(), dispatcher.connections[chosen].Identity)
 	} else {
 		switch data := value.Interface().(type) {
 		case rotonde.Event:
-			log.Info("Dispatching event ", data.Identifier)
-			dispatcher.dispatchEvent(chosen, &data)
+			dispatcher.routeEvent(chosen, data)
 		case rotonde.Action:
-			log.Info("Dispatching action ", data.Identifier)
-			dispatcher.dispatchAction(chosen, &data)
+			dispatcher.routeAction(chosen, data)
+		case rotonde.Batch:
+			log.Info("Unpacking batch of ", len(data.Packets))
+			for _, packet := range data.Packets {
+				switch batched := packet.(type) {
+				case rotonde.Event:
+					dispatcher.routeEvent(chosen, batched)
+				case rotonde.Action:
+					dispatcher.routeAction(chosen, batched)
+				}
+			}
 		case rotonde.Subscription:
 			log.Info("Executing subscribe ", data.Identifier)
 			connection := dispatcher.connections[chosen]
+			if data.Identifier == PeerConnectedIdentifier && connection.isSubscribed(data.Identifier) == false {
+				dispatcher.sendConnectedPeers(chosen)
+			}
+			if connection.isSubscribed(data.Identifier) == false {
+				dispatcher.forwardSubscription(chosen, data.Identifier, data)
+			}
 			connection.addSubscription(data.Identifier)
 		case rotonde.Unsubscription:
 			log.Info("Executing unsubscribe ", data.Identifier)
 			connection := dispatcher.connections[chosen]
+			if connection.isSubscribed(data.Identifier) {
+				dispatcher.forwardSubscription(chosen, data.Identifier, data)
+			}
 			connection.removeSubscription(data.Identifier)
 		case rotonde.Definition:
 			log.Info("Dispatching Definition message ", data.Identifier)
@@ -252,6 +433,9 @@ func (dispatcher *Dispatcher) processChannels() {
 				connection.actions = rotonde.PushDefinition(connection.actions, &data)
 			} else if data.Type == "event" {
 				connection.events = rotonde.PushDefinition(connection.events, &data)
+				if connection.ForwardSubscriptions && dispatcher.isSubscribed(data.Identifier, chosen) {
+					connection.Write(rotonde.Subscription{Identifier: data.Identifier})
+				}
 			}
 			dispatcher.dispatchDefinition(chosen, &data)
 		case rotonde.UnDefinition:
@@ -274,12 +458,21 @@ func (dispatcher *Dispatcher) processChannels() {
 				}
 				definition = def
 				connection.events = rotonde.RemoveDefinition(connection.events, data.Identifier)
+			} else {
+				// in-process modules send packets that didn't go through FromPacket
+				log.Warningf("Ignoring UnDefinition of unknown type \"%s\"", data.Type)
+				break
 			}
 			unDefinition := rotonde.UnDefinition(*definition)
 			dispatcher.dispatchUnDefinition(chosen, &unDefinition)
+		case rotonde.Auth:
+			log.Warning("Ignoring auth packet on an already established connection")
+		case rotonde.Hello:
+			dispatcher.negotiateVersion(chosen, data.Version)
 		case *Connection:
 			log.Info("Add connection")
 			dispatcher.addConnection(data) // data is already a pointer
+			close(data.added)
 		default:
 			log.Warning("Oops got some unknown object in the dispatcher, ignoring.")
 		}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/HackerLoop/rotonde/shared"
+)
+
+func TestDispatcherBatch(t *testing.T) {
+	d := NewDispatcher()
+	go d.Start()
+
+	watcher := NewConnection()
+	d.AddConnection(watcher)
+	module := NewConnection()
+	d.AddConnection(module)
+	module.OutChan <- rotonde.Definition{Identifier: "LED", Type: "action", Fields: rotonde.FieldDefinitions{{Name: "level", Type: "number", Required: true}}}
+	module.OutChan <- rotonde.Subscription{Identifier: "BUTTON"}
+	waitForDefinition(t, watcher, "LED")
+
+	sender := NewConnection()
+	d.AddConnection(sender)
+	sender.OutChan <- rotonde.Batch{Packets: []interface{}{
+		rotonde.Action{Identifier: "LED", Data: rotonde.Object{"level": "high"}}, // invalid, dropped
+		rotonde.Action{Identifier: "LED", Data: rotonde.Object{"level": 1.0}},
+		rotonde.Event{Identifier: "BUTTON", Data: rotonde.Object{}},
+	}}
+
+	// the packets of the batch are routed one by one, in order
+	if action, ok := receive(t, module).(rotonde.Action); ok == false || action.Data["level"] != 1.0 {
+		t.Fatalf("Expected the valid LED action, got %+v", action)
+	}
+	if event, ok := receive(t, module).(rotonde.Event); ok == false || event.Identifier != "BUTTON" {
+		t.Fatalf("Expected the BUTTON event, got %+v", event)
+	}
+	if len(module.InChan) != 0 {
+		t.Errorf("Expected the invalid action to be dropped, got %d more packets", len(module.InChan))
+	}
+}
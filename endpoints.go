@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/HackerLoop/rotonde/shared"
+)
+
+// Endpoint configures a websocket mount point
+type Endpoint struct {
+	Encoding string   `json:"encoding"` // "json" or "msgpack", negotiated with the subprotocol when empty
+	Auth     bool     `json:"auth"`     // clients have to authenticate, requires -auth-tokens
+	Packets  []string `json:"packets"`  // packet types clients may send (eg. "sub", "action"), all when empty
+}
+
+// gatewayPaths are used by the HTTP gateway, they can't be websocket endpoints
+var gatewayPaths = []string{"/actions/", "/events/", "/events", "/definitions", "/schemas", "/schemas/"}
+
+var packetTypes = []string{"event", "action", "sub", "unsub", "def", "undef"}
+
+// DefaultEndpoints is the configuration when no endpoints file is given, a
+// single endpoint on "/", with authentication when tokens are available.
+func DefaultEndpoints(authenticator *Authenticator) map[string]*Endpoint {
+	return map[string]*Endpoint{
+		"/": {Auth: authenticator != nil},
+	}
+}
+
+// LoadEndpoints reads the endpoints file, of the form:
+//
+//	{
+//	  "endpoints": {
+//	    "/v1": {"auth": true},
+//	    "/readonly": {"encoding": "json", "packets": ["sub", "unsub"]}
+//	  }
+//	}
+func LoadEndpoints(path string, authenticator *Authenticator) (map[string]*Endpoint, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := struct {
+		Endpoints map[string]*Endpoint `json:"endpoints"`
+	}{}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("Could not parse %s: %s", path, err)
+	}
+	if len(config.Endpoints) == 0 {
+		return nil, fmt.Errorf("No endpoints found in %s", path)
+	}
+
+	for mountPoint, endpoint := range config.Endpoints {
+		if strings.HasPrefix(mountPoint, "/") == false {
+			return nil, fmt.Errorf("Endpoint %s has to start with /", mountPoint)
+		}
+		for _, gatewayPath := range gatewayPaths {
+			if mountPoint == gatewayPath {
+				return nil, fmt.Errorf("Endpoint %s is used by the HTTP gateway", mountPoint)
+			}
+		}
+		if endpoint.Encoding != "" && endpoint.Encoding != "json" && endpoint.Encoding != "msgpack" {
+			return nil, fmt.Errorf("Unknown encoding %s for endpoint %s", endpoint.Encoding, mountPoint)
+		}
+		if endpoint.Auth && authenticator == nil {
+			return nil, fmt.Errorf("Endpoint %s requires authentication, but no -auth-tokens given", mountPoint)
+		}
+		for _, packet := range endpoint.Packets {
+			if contains(packetTypes, packet) == false {
+				return nil, fmt.Errorf("Unknown packet type %s for endpoint %s", packet, mountPoint)
+			}
+		}
+	}
+	return config.Endpoints, nil
+}
+
+// allows returns true if clients of this endpoint may send the packet
+func (endpoint *Endpoint) allows(packet interface{}) bool {
+	if len(endpoint.Packets) == 0 {
+		return true
+	}
+	switch data := packet.(type) {
+	case rotonde.Auth, rotonde.Hello:
+		return true
+	case rotonde.Batch:
+		// a batch is allowed when all its packets are
+		for _, batched := range data.Packets {
+			if endpoint.allows(batched) == false {
+				return false
+			}
+		}
+		return true
+	}
+	wrapped, err := rotonde.ToPacket(packet)
+	return err == nil && contains(endpoint.Packets, wrapped.Type)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
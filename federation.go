@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HackerLoop/rotonde/shared"
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/websocket"
+)
+
+// FederationSeparator separates the instance prefixes of federated identifiers
+const FederationSeparator = ":"
+
+// MaxFederationHops is the maximum number of instance prefixes of a federated
+// identifier, definitions going through more links are not mirrored
+const MaxFederationHops = 4
+
+// FederationParameter is the query parameter carrying the name of the
+// instance opening a federation link
+const FederationParameter = "federation"
+
+// FederationLink connects the dispatcher to a remote rotonde instance, the
+// definitions of each instance are mirrored on the other one, prefixed with
+// the name of the instance that provides them (eg. "kitchen:TURN_LIGHT_ON").
+// Actions sent to a prefixed identifier are forwarded to the instance that
+// provides it, and events are forwarded to the other instance once one of
+// its modules subscribed to them.
+type FederationLink struct {
+	Name string // name of the remote instance
+	URL  string // websocket URL of the remote instance, tokens go in the query string
+}
+
+// ParseFederationLinks parses a comma separated list of name=url links
+func ParseFederationLinks(value string) ([]FederationLink, error) {
+	var links []FederationLink
+	for _, item := range splitList(value) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid federation link %s, expected name=url", item)
+		}
+		if strings.Contains(parts[0], FederationSeparator) {
+			return nil, fmt.Errorf("Federation link name %s can't contain %s", parts[0], FederationSeparator)
+		}
+		links = append(links, FederationLink{Name: parts[0], URL: parts[1]})
+	}
+	return links, nil
+}
+
+// StartFederation keeps the link connected, reconnecting with backoff
+func StartFederation(d *Dispatcher, instance string, link FederationLink) {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		if err := runFederationLink(d, instance, link); err != nil {
+			log.Warningf("Federation link %s: %s", link.Name, err)
+		}
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		time.Sleep(backoff)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+// isFederated returns false for identifiers that would create a loop: the
+// ones that already went through the local or remote instance, or through
+// too many links.
+func isFederated(identifier, instance string, link FederationLink) bool {
+	prefixes := strings.Split(identifier, FederationSeparator)
+	prefixes = prefixes[:len(prefixes)-1]
+	if len(prefixes) >= MaxFederationHops {
+		return false
+	}
+	for _, prefix := range prefixes {
+		if prefix == instance || prefix == link.Name {
+			return false
+		}
+	}
+	return true
+}
+
+// isReverseLink returns true when the instance named remote opens a link to
+// an instance that federates with it too. Both links would mirror the same
+// definitions, and deliver actions and events twice, only the link opened by
+// the instance with the lowest name is kept.
+func isReverseLink(remote, instance string, links []FederationLink) bool {
+	if remote == "" || instance >= remote {
+		return false
+	}
+	for _, link := range links {
+		if link.Name == remote {
+			return true
+		}
+	}
+	return false
+}
+
+func runFederationLink(d *Dispatcher, instance string, link FederationLink) error {
+	linkURL, err := url.Parse(link.URL)
+	if err != nil {
+		return err
+	}
+	query := linkURL.Query()
+	query.Set(FederationParameter, instance)
+	linkURL.RawQuery = query.Encode()
+	versionURL, err := rotonde.WithVersion(linkURL.String(), rotonde.ProtocolVersion)
+	if err != nil {
+		return err
+	}
+	conn, response, err := websocket.DefaultDialer.Dial(versionURL, nil)
+	if err != nil {
+		if response != nil && response.StatusCode == http.StatusConflict {
+			return fmt.Errorf("%s federates with this instance too, its link is used", link.Name)
+		}
+		return err
+	}
+	defer conn.Close()
+	log.Infof("Federation link %s connected to %s", link.Name, link.URL)
+
+	localPrefix := instance + FederationSeparator
+	remotePrefix := link.Name + FederationSeparator
+
+	c := NewConnection()
+	c.ForwardSubscriptions = true
+	d.AddConnection(c)
+	defer c.Close()
+
+	var linkErr error // set by the reader before it returns
+	errChan := make(chan error, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		// the writer goroutine is the only one writing to conn
+		for {
+			var remotePacket interface{}
+			select {
+			case dispatcherPacket := <-c.InChan:
+				// local packets, local identifiers are prefixed with the local instance name
+				switch data := dispatcherPacket.(type) {
+				case rotonde.Definition:
+					if isFederated(data.Identifier, instance, link) == false {
+						continue
+					}
+					data.Identifier = localPrefix + data.Identifier
+					remotePacket = data
+				case rotonde.UnDefinition:
+					if isFederated(data.Identifier, instance, link) == false {
+						continue
+					}
+					data.Identifier = localPrefix + data.Identifier
+					remotePacket = data
+				case rotonde.Subscription:
+					// local modules subscribed to an event we mirrored from the remote
+					if strings.HasPrefix(data.Identifier, remotePrefix) == false {
+						continue
+					}
+					data.Identifier = strings.TrimPrefix(data.Identifier, remotePrefix)
+					remotePacket = data
+				case rotonde.Unsubscription:
+					if strings.HasPrefix(data.Identifier, remotePrefix) == false {
+						continue
+					}
+					data.Identifier = strings.TrimPrefix(data.Identifier, remotePrefix)
+					remotePacket = data
+				case rotonde.Event:
+					// we only receive the events someone subscribed to on the remote
+					data.Identifier = localPrefix + data.Identifier
+					remotePacket = data
+				case rotonde.Action:
+					// we only receive the actions of the definitions we mirrored from the remote
+					if strings.HasPrefix(data.Identifier, remotePrefix) == false {
+						continue
+					}
+					data.Identifier = strings.TrimPrefix(data.Identifier, remotePrefix)
+					remotePacket = data
+				default:
+					continue
+				}
+			case <-errChan:
+				return
+			}
+
+			jsonPacket, err := rotonde.ToJSON(remotePacket)
+			if err != nil {
+				log.Warning(err)
+				continue
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, jsonPacket); err != nil {
+				log.Warning(err)
+				// unblocks the reader
+				conn.Close()
+				return
+			}
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			messageType, reader, err := conn.NextReader()
+			if err != nil {
+				linkErr = err
+				errChan <- err
+				return
+			}
+			if messageType != websocket.TextMessage {
+				continue
+			}
+			remotePacket, err := rotonde.FromJSON(reader)
+			if err != nil {
+				log.Warning(err)
+				continue
+			}
+
+			// batches are unpacked, the dispatcher routes each packet anyway
+			remotePackets := []interface{}{remotePacket}
+			if batch, ok := remotePacket.(rotonde.Batch); ok {
+				remotePackets = batch.Packets
+			}
+			for _, remotePacket := range remotePackets {
+				// remote packets, remote identifiers are prefixed with the remote instance name
+				switch data := remotePacket.(type) {
+				case rotonde.Definition:
+					if isFederated(data.Identifier, instance, link) == false {
+						continue
+					}
+					data.Identifier = remotePrefix + data.Identifier
+					c.OutChan <- data
+				case rotonde.UnDefinition:
+					if isFederated(data.Identifier, instance, link) == false {
+						continue
+					}
+					data.Identifier = remotePrefix + data.Identifier
+					c.OutChan <- data
+				case rotonde.Subscription:
+					// remote modules subscribed to an event we mirrored on the remote
+					if strings.HasPrefix(data.Identifier, localPrefix) == false {
+						continue
+					}
+					data.Identifier = strings.TrimPrefix(data.Identifier, localPrefix)
+					c.OutChan <- data
+				case rotonde.Unsubscription:
+					if strings.HasPrefix(data.Identifier, localPrefix) == false {
+						continue
+					}
+					data.Identifier = strings.TrimPrefix(data.Identifier, localPrefix)
+					c.OutChan <- data
+				case rotonde.Event:
+					data.Identifier = remotePrefix + data.Identifier
+					c.OutChan <- data
+				case rotonde.Action:
+					// the remote only sends us the actions of the definitions we mirrored
+					if strings.HasPrefix(data.Identifier, localPrefix) == false {
+						continue
+					}
+					data.Identifier = strings.TrimPrefix(data.Identifier, localPrefix)
+					c.OutChan <- data
+				}
+			}
+		}
+	}()
+
+	wg.Wait()
+	log.Infof("Federation link %s closed", link.Name)
+	return linkErr
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HackerLoop/rotonde/shared"
+)
+
+func TestIsReverseLink(t *testing.T) {
+	links := []FederationLink{{Name: "livingroom", URL: "ws://livingroom.local:4224/"}}
+	tests := []struct {
+		remote   string
+		instance string
+		reverse  bool
+	}{
+		{"", "kitchen", false},          // not a federation link
+		{"garage", "kitchen", false},    // this instance doesn't federate with the remote
+		{"livingroom", "kitchen", true}, // both federate, the link of kitchen is kept
+		{"livingroom", "museum", false}, // both federate, the link of livingroom is kept
+	}
+	for _, test := range tests {
+		if isReverseLink(test.remote, test.instance, links) != test.reverse {
+			t.Errorf("%s opening a link to %s: expected reverse %v", test.remote, test.instance, test.reverse)
+		}
+	}
+}
+
+// waitForDefinition reads c until the definition of identifier
+func waitForDefinition(t *testing.T, c *Connection, identifier string) {
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case packet := <-c.InChan:
+			if definition, ok := packet.(rotonde.Definition); ok && definition.Identifier == identifier {
+				return
+			}
+		case <-deadline:
+			t.Fatal("Timeout waiting for the definition of ", identifier)
+		}
+	}
+}
+
+func TestFederationSubscriptions(t *testing.T) {
+	remote := NewDispatcher()
+	go remote.Start()
+	server := httptest.NewServer(websocketHandler(remote, WebsocketOptions{Instance: "livingroom"}, DefaultEndpoints(nil)["/"]))
+	defer server.Close()
+
+	// provides TEMPERATURE on the remote instance, and is told when someone subscribes to it
+	provider := NewConnection()
+	provider.ForwardSubscriptions = true
+	remote.AddConnection(provider)
+	provider.OutChan <- rotonde.Definition{Identifier: "TEMPERATURE", Type: "event"}
+
+	local := NewDispatcher()
+	go local.Start()
+	link := FederationLink{Name: "livingroom", URL: "ws" + strings.TrimPrefix(server.URL, "http")}
+	go runFederationLink(local, "kitchen", link)
+
+	module := NewConnection()
+	local.AddConnection(module)
+	waitForDefinition(t, module, "livingroom:TEMPERATURE")
+
+	// nobody subscribed, the event is not pulled over the link
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case packet := <-provider.InChan:
+		if _, ok := packet.(rotonde.Subscription); ok {
+			t.Fatal("The federation link subscribed without local subscriber")
+		}
+	default:
+	}
+
+	module.OutChan <- rotonde.Subscription{Identifier: "livingroom:TEMPERATURE"}
+	if subscription, ok := receive(t, provider).(rotonde.Subscription); ok == false || subscription.Identifier != "TEMPERATURE" {
+		t.Fatalf("Expected the subscription to be forwarded to the remote, got %+v", subscription)
+	}
+
+	provider.OutChan <- rotonde.Event{Identifier: "TEMPERATURE", Data: rotonde.Object{"value": 21.5}}
+	if event, ok := receive(t, module).(rotonde.Event); ok == false || event.Identifier != "livingroom:TEMPERATURE" {
+		t.Fatalf("Expected the remote event, got %+v", event)
+	}
+
+	module.OutChan <- rotonde.Unsubscription{Identifier: "livingroom:TEMPERATURE"}
+	if unsubscription, ok := receive(t, provider).(rotonde.Unsubscription); ok == false || unsubscription.Identifier != "TEMPERATURE" {
+		t.Fatalf("Expected the unsubscription to be forwarded to the remote, got %+v", unsubscription)
+	}
+}
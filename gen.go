@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/HackerLoop/rotonde/shared"
+)
+
+// runGen implements the gen command, it generates Go structs and
+// send/subscribe helpers for the definitions of a running instance, or of a
+// saved definitions JSON file (as returned by GET /definitions).
+func runGen(args []string) error {
+	flags := flag.NewFlagSet("gen", flag.ExitOnError)
+	url := flags.String("url", "http://localhost:4224", "HTTP URL of the rotonde instance to read the definitions from")
+	definitionsFile := flags.String("definitions", "", "saved definitions JSON file, used instead of -url")
+	packageName := flags.String("package", "rotondetypes", "package of the generated code")
+	output := flags.String("o", "", "output file, stdout when empty")
+	token := flags.String("token", "", "token sent to the instance when authentication is enabled")
+	flags.Parse(args)
+
+	var reader io.Reader
+	if *definitionsFile != "" {
+		file, err := os.Open(*definitionsFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	} else {
+		request, err := http.NewRequest("GET", strings.TrimSuffix(*url, "/")+"/definitions", nil)
+		if err != nil {
+			return err
+		}
+		if *token != "" {
+			request.Header.Set("Authorization", "Bearer "+*token)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("GET /definitions: %s", response.Status)
+		}
+		reader = response.Body
+	}
+
+	definitions := rotonde.Definitions{}
+	if err := json.NewDecoder(reader).Decode(&definitions); err != nil {
+		return err
+	}
+
+	code, err := generateCode(*packageName, definitions)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(code)
+		return err
+	}
+	return ioutil.WriteFile(*output, code, 0644)
+}
+
+var genTemplate = template.Must(template.New("gen").Funcs(template.FuncMap{
+	"goType":  goType,
+	"comment": comment,
+	"jsonTag": jsonTag,
+}).Parse(`// Code generated by "rotonde gen", DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"encoding/json"
+
+	"github.com/HackerLoop/rotonde/shared"
+	"github.com/HackerLoop/rotonde/shared/client"
+)
+{{range .Definitions}}{{$name := .Name}}
+// {{$name}} is the data of the {{comment .Identifier}} {{comment .Type}}{{if .IsArray}} (declared as array){{end}}
+type {{$name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{goType .Type}} {{jsonTag .FieldDefinition.Name}}{{if .Units}} // {{comment .Units}}{{end}}
+{{- end}}
+}
+{{if eq .Type "action"}}
+// Send{{$name}} sends the {{comment .Identifier}} action
+func Send{{$name}}(c *client.Client, data {{$name}}) error {
+	object, err := toObject(data)
+	if err != nil {
+		return err
+	}
+	return c.SendAction({{printf "%q" .Identifier}}, object)
+}
+
+// On{{$name}} registers a handler for the {{comment .Identifier}} action
+func On{{$name}}(c *client.Client, handler func({{$name}})) {
+	c.OnAction({{printf "%q" .Identifier}}, func(action rotonde.Action) {
+		data := {{$name}}{}
+		if fromObject(action.Data, &data) == nil {
+			handler(data)
+		}
+	})
+}
+{{else}}
+// Send{{$name}} sends the {{comment .Identifier}} event
+func Send{{$name}}(c *client.Client, data {{$name}}) error {
+	object, err := toObject(data)
+	if err != nil {
+		return err
+	}
+	return c.SendEvent({{printf "%q" .Identifier}}, object)
+}
+
+// On{{$name}} subscribes to the {{comment .Identifier}} event
+func On{{$name}}(c *client.Client, handler func({{$name}})) error {
+	return c.OnEvent({{printf "%q" .Identifier}}, func(event rotonde.Event) {
+		data := {{$name}}{}
+		if fromObject(event.Data, &data) == nil {
+			handler(data)
+		}
+	})
+}
+{{end}}{{end}}
+func toObject(data interface{}) (rotonde.Object, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	object := rotonde.Object{}
+	err = json.Unmarshal(jsonData, &object)
+	return object, err
+}
+
+func fromObject(object rotonde.Object, data interface{}) error {
+	jsonData, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, data)
+}
+`))
+
+// genDefinition is a definition with the Go names of its type and fields
+type genDefinition struct {
+	*rotonde.Definition
+	Name   string
+	Fields []genField
+}
+
+type genField struct {
+	*rotonde.FieldDefinition
+	Name string
+}
+
+// generateCode renders and formats the Go code for the definitions
+func generateCode(packageName string, definitions rotonde.Definitions) ([]byte, error) {
+	// each definition declares a type, and its Send and On helpers
+	declared := map[string]bool{}
+	genDefinitions := make([]genDefinition, 0, len(definitions))
+	for _, definition := range definitions {
+		name := uniqueName(goName(definition.Identifier), func(name string) bool {
+			return declared[name] || declared["Send"+name] || declared["On"+name]
+		})
+		declared[name], declared["Send"+name], declared["On"+name] = true, true, true
+
+		genDefinition := genDefinition{Definition: definition, Name: name}
+		fields := map[string]bool{}
+		for _, field := range definition.Fields {
+			fieldName := uniqueName(goName(field.Name), func(name string) bool { return fields[name] })
+			fields[fieldName] = true
+			genDefinition.Fields = append(genDefinition.Fields, genField{field, fieldName})
+		}
+		genDefinitions = append(genDefinitions, genDefinition)
+	}
+
+	var buffer bytes.Buffer
+	err := genTemplate.Execute(&buffer, struct {
+		Package     string
+		Definitions []genDefinition
+	}{packageName, genDefinitions})
+	if err != nil {
+		return nil, err
+	}
+	return format.Source(buffer.Bytes())
+}
+
+// goName converts an identifier (eg. TURN_LIGHT_ON or kitchen:TEMPERATURE) to an exported Go name
+func goName(identifier string) string {
+	words := strings.FieldsFunc(identifier, func(r rune) bool {
+		return unicode.IsLetter(r) == false && unicode.IsDigit(r) == false
+	})
+	name := ""
+	for _, word := range words {
+		// UPPER_CASE words are capitalized, camelCase words keep their case
+		if word == strings.ToUpper(word) {
+			word = strings.ToLower(word)
+		}
+		runes := []rune(word)
+		name += strings.ToUpper(string(runes[0])) + string(runes[1:])
+	}
+	if first, _ := utf8.DecodeRuneInString(name); name == "" || unicode.IsDigit(first) {
+		name = "X" + name
+	}
+	return name
+}
+
+// uniqueName suffixes name with a number while it is taken, identifiers like
+// TURN_ON and turn_on have the same Go name
+func uniqueName(name string, taken func(string) bool) string {
+	unique := name
+	for i := 2; taken(unique); i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	return unique
+}
+
+// comment makes text safe to put in a // comment
+func comment(text string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(text)
+}
+
+// jsonTag returns the quoted struct tag mapping a field to its JSON name
+func jsonTag(name string) string {
+	tag := "json:" + strconv.Quote(name)
+	if strconv.CanBackquote(tag) {
+		return "`" + tag + "`"
+	}
+	return strconv.Quote(tag)
+}
+
+// goType maps the type of a field definition to a Go type
+func goType(fieldType string) string {
+	switch fieldType {
+	case "string":
+		return "string"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "object":
+		return "map[string]interface{}"
+	case "array":
+		return "[]interface{}"
+	}
+	return "interface{}"
+}
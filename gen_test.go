@@ -0,0 +1,111 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/HackerLoop/rotonde/shared"
+)
+
+// typeCheck parses and type-checks the generated code, and returns its
+// package and the names of its functions
+func typeCheck(t *testing.T, code []byte) (*types.Package, []string) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "definitions.go", code, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("The generated code doesn't parse: %s\n%s", err, code)
+	}
+	config := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	pkg, err := config.Check("home", fset, []*ast.File{file}, nil)
+	if err != nil {
+		t.Fatalf("The generated code doesn't type-check: %s\n%s", err, code)
+	}
+	var functions []string
+	for _, declaration := range file.Decls {
+		if function, ok := declaration.(*ast.FuncDecl); ok {
+			functions = append(functions, function.Name.Name)
+		}
+	}
+	return pkg, functions
+}
+
+func TestGenerateCode(t *testing.T) {
+	definitions := rotonde.Definitions{
+		{Identifier: "TURN_ON", Type: "action", Fields: rotonde.FieldDefinitions{
+			{Name: "level", Type: "number", Units: "percent\nfunc init() { panic(0) }"},
+			{Name: "a-b", Type: "string"},
+			{Name: "a_b", Type: "boolean"},
+			{Name: `quoted"name`, Type: "array"},
+		}},
+		{Identifier: "turn_on", Type: "event"},
+		{Identifier: "ON", Type: "event"}, // its helpers would be OnOn and SendOn, SendOn is not taken
+		{Identifier: "kitchen:TEMPERATURE", Type: "event", Fields: rotonde.FieldDefinitions{{Name: "value", Type: "number"}}},
+		{Identifier: `BAD") ; panic("injected`, Type: "action"},
+		{Identifier: "été", Type: "event"},
+	}
+
+	code, err := generateCode("home", definitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, functions := typeCheck(t, code)
+
+	for _, name := range []string{"TurnOn", "SendTurnOn", "OnTurnOn", "TurnOn2", "SendTurnOn2", "On", "KitchenTemperature", "BadPanicInjected", "Été"} {
+		if pkg.Scope().Lookup(name) == nil {
+			t.Errorf("%s is not declared", name)
+		}
+	}
+	turnOn := pkg.Scope().Lookup("TurnOn").Type().Underlying().(*types.Struct)
+	if turnOn.NumFields() != 4 || turnOn.Field(1).Name() != "AB" || turnOn.Field(2).Name() != "AB2" {
+		t.Errorf("Unexpected TurnOn fields %v", turnOn)
+	}
+	if tag := turnOn.Tag(3); tag != `json:"quoted\"name"` {
+		t.Errorf("Unexpected tag %s", tag)
+	}
+	// a Send and On helper per definition, toObject and fromObject
+	if len(functions) != 2*len(definitions)+2 {
+		t.Errorf("Code injected in the generated file, functions %v\n%s", functions, code)
+	}
+}
+
+func TestRunGenToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/definitions" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`[{"identifier": "LED", "type": "action", "fields": [{"name": "level", "type": "number"}]}]`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "rotonde-gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "definitions.go")
+
+	if err := runGen([]string{"-url", server.URL, "-o", output}); err == nil || strings.Contains(err.Error(), "401") == false {
+		t.Errorf("Expected a 401 without token, got %v", err)
+	}
+	if err := runGen([]string{"-url", server.URL, "-token", "secret", "-o", output}); err != nil {
+		t.Fatal(err)
+	}
+	code, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg, _ := typeCheck(t, code); pkg.Scope().Lookup("SendLed") == nil {
+		t.Error("SendLed is not declared")
+	}
+}
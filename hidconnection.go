@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
@@ -16,13 +17,182 @@ const ROTONDE_VENDOR_ID = 0x0042
 const FEATURE_STOP = 0x0
 const FEATURE_START = 0x1
 const FEATURE_DEFINITION = 0xff
+const FEATURE_CREDITS = 0x2
+const FEATURE_ABORT = 0x3
 const MaxHIDFrameSize = 64
 const HeaderLength = 4
+const ExtendedHeaderLength = 6
+
+// frame header versions, the version byte follows the 0x3c frame start byte.
+// Extended frames carry a 32 bits length, they are only used for packets
+// that don't fit in the 16 bits length of the original header.
+const FrameVersion = 0x40
+const ExtendedFrameVersion = 0x41
+
+// MaxHIDPacketSize is the biggest packet accepted on the HID link, in both directions
+const MaxHIDPacketSize = 1 << 24
+
+var errPacketTooLarge = fmt.Errorf("Packet exceeds MaxHIDPacketSize (%d bytes)", MaxHIDPacketSize)
+
+// CreditsTimeout is how long the writer waits for the device to grant credits
+// before aborting the packet being sent
+const CreditsTimeout = 3 * time.Second
+
+// HIDQueueLength is the number of packets queued for a device, packets are
+// dropped when the device doesn't keep up, instead of blocking the dispatcher
+const HIDQueueLength = 100
+
+// CreditsMarker starts the FEATURE_CREDITS report of the devices supporting
+// flow control, so that legacy firmwares answering every feature report (eg.
+// with zeros) are not mistaken for devices without credits
+const CreditsMarker = 0xfc
+
+// HotplugPollInterval is the fallback enumeration interval when hotplug events are available
+const HotplugPollInterval = 10 * time.Second
+
+// HIDOpenRetryInterval is the first delay before opening again a device that
+// failed to open, it doubles up to one second while the device keeps
+// failing. Opening fails right after the hotplug event, before udev has
+// applied its rules and permissions.
+const HIDOpenRetryInterval = 100 * time.Millisecond
+
+// HIDReadTimeout is how long a read waits for data before checking if the
+// connection was stopped, in milliseconds
+const HIDReadTimeout = 100
+
+// hidFlowControl implements a credit-based flow control, so the host never
+// overruns the buffers of the device.
+// The host asks for credits with a FEATURE_CREDITS feature report, the device
+// answers with a report made of CreditsMarker and the number of frames it can
+// currently accept, each frame written consumes one credit. Devices that
+// don't answer the first FEATURE_CREDITS report with the marker get the
+// previous behaviour, frames are written without waiting.
+type hidFlowControl struct {
+	cc      hidFeatureReporter
+	enabled bool
+	credits int
+	timeout time.Duration
+}
+
+// hidFeatureReporter is the part of hid.Device used by the flow control
+type hidFeatureReporter interface {
+	SendFeatureReport(b []byte) (int, error)
+	GetFeatureReport(b []byte) (int, error)
+}
+
+func newHIDFlowControl(cc hidFeatureReporter) *hidFlowControl {
+	flow := &hidFlowControl{cc: cc, timeout: CreditsTimeout}
+	if err := flow.refill(); err != nil {
+		log.Info("HID device does not support flow control: ", err)
+		return flow
+	}
+	flow.enabled = true
+	return flow
+}
+
+// refill asks the device how many frames it can accept
+func (flow *hidFlowControl) refill() error {
+	if _, err := flow.cc.SendFeatureReport([]byte{0x00, FEATURE_CREDITS}); err != nil {
+		return err
+	}
+	report := []byte{0x00, 0x00, 0x00}
+	n, err := flow.cc.GetFeatureReport(report)
+	if err != nil {
+		return err
+	}
+	if n < len(report) {
+		return fmt.Errorf("Short FEATURE_CREDITS report")
+	}
+	if report[1] != CreditsMarker {
+		return fmt.Errorf("No credits marker in the FEATURE_CREDITS report")
+	}
+	flow.credits = int(report[2])
+	return nil
+}
+
+// acquire blocks until the device has granted at least one credit, and
+// consumes it. When the device doesn't grant credits in time, or stops
+// answering, an error is returned so the packet is aborted, flow control
+// stays enabled for the next packets.
+func (flow *hidFlowControl) acquire() error {
+	if flow.enabled == false {
+		return nil
+	}
+	deadline := time.Now().Add(flow.timeout)
+	for flow.credits <= 0 {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timeout waiting for HID device credits")
+		}
+		if err := flow.refill(); err != nil {
+			return fmt.Errorf("HID device credits failed: %s", err)
+		}
+		if flow.credits <= 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	flow.credits--
+	return nil
+}
+
+// hidFilter decides which packets the writer goroutine encodes and transmits
+// to a HID device. Events and actions are already routed by the dispatcher on
+// the subscriptions and definitions of the connection, so they always go
+// through, and def packets never do. The filter only decides for undef
+// packets, which the dispatcher sends to every connection: the device only
+// needs to know when something it subscribed to or defined goes away. It is
+// fed by the packets the device sends (sub, unsub, def, undef), the
+// frameReader and the writer run in different goroutines, hence the mutex.
+type hidFilter struct {
+	mutex         sync.Mutex
+	subscriptions map[string]bool // events the device subscribed to
+	actions       map[string]bool // actions the device defined
+}
+
+func newHIDFilter() *hidFilter {
+	filter := new(hidFilter)
+	filter.subscriptions = make(map[string]bool)
+	filter.actions = make(map[string]bool)
+	return filter
+}
+
+// update records the identifiers used by the device, for its undef packets
+func (filter *hidFilter) update(packet interface{}) {
+	filter.mutex.Lock()
+	defer filter.mutex.Unlock()
+
+	switch data := packet.(type) {
+	case rotonde.Subscription:
+		filter.subscriptions[data.Identifier] = true
+	case rotonde.Unsubscription:
+		delete(filter.subscriptions, data.Identifier)
+	case rotonde.Definition:
+		if data.Type == "action" {
+			filter.actions[data.Identifier] = true
+		}
+	case rotonde.UnDefinition:
+		if data.Type == "action" {
+			delete(filter.actions, data.Identifier)
+		}
+	}
+}
+
+// wants returns true if the packet has to be sent to the device
+func (filter *hidFilter) wants(packet interface{}) bool {
+	switch data := packet.(type) {
+	case rotonde.Event, rotonde.Action:
+		return true
+	case rotonde.UnDefinition:
+		filter.mutex.Lock()
+		defer filter.mutex.Unlock()
+		return filter.subscriptions[data.Identifier] || filter.actions[data.Identifier]
+	}
+	return false
+}
 
 func StartHID(d *Dispatcher) {
-	var isOpen, openned, closed = func() (func(*hid.DeviceInfo) bool, func(*hid.DeviceInfo), func(*hid.DeviceInfo)) {
+	var isOpen, openned, closed, closeMissing = func() (func(*hid.DeviceInfo) bool, func(*hid.DeviceInfo) chan struct{}, func(*hid.DeviceInfo, chan struct{}), func([]*hid.DeviceInfo)) {
 		var mutex = new(sync.Mutex)
-		var openPorts = map[string]bool{}
+		var openPorts = map[string]chan struct{}{} // maps opened devices to the channel that stops their connection
 
 		var deviceId = func(device *hid.DeviceInfo) string {
 			return fmt.Sprintf("%x:%x:%s", device.VendorId, device.ProductId, device.SerialNumber)
@@ -31,27 +201,61 @@ func StartHID(d *Dispatcher) {
 		return func(device *hid.DeviceInfo) bool {
 				mutex.Lock()
 				defer mutex.Unlock()
-				isOpen, ok := openPorts[deviceId(device)]
-				return ok && isOpen
-			}, func(device *hid.DeviceInfo) {
+				_, ok := openPorts[deviceId(device)]
+				return ok
+			}, func(device *hid.DeviceInfo) chan struct{} {
+				mutex.Lock()
+				defer mutex.Unlock()
+				stop := make(chan struct{})
+				openPorts[deviceId(device)] = stop
+				return stop
+			}, func(device *hid.DeviceInfo, stop chan struct{}) {
 				mutex.Lock()
 				defer mutex.Unlock()
-				openPorts[deviceId(device)] = true
-			}, func(device *hid.DeviceInfo) {
+				// the entry might already belong to a new connection, if the
+				// device was removed and plugged again
+				if openPorts[deviceId(device)] == stop {
+					delete(openPorts, deviceId(device))
+				}
+			}, func(devices []*hid.DeviceInfo) {
 				mutex.Lock()
 				defer mutex.Unlock()
-				openPorts[deviceId(device)] = false
+				present := map[string]bool{}
+				for _, device := range devices {
+					present[deviceId(device)] = true
+				}
+				for id, stop := range openPorts {
+					if present[id] {
+						continue
+					}
+					log.Infof("HID device %s removed", id)
+					close(stop)
+					delete(openPorts, id)
+				}
 			}
 	}()
 
+	// on platforms that support it, hotplug events trigger the enumeration,
+	// polling is kept as a fallback in case an event is missed.
+	pollInterval := 1 * time.Second
+	hotplug, err := watchHotplug()
+	if err != nil {
+		log.Info("HID hotplug watcher not available, polling devices: ", err)
+	} else {
+		pollInterval = HotplugPollInterval
+	}
+
 	go func() {
+		retryInterval := HIDOpenRetryInterval
 		for {
 			devices, err := hid.Enumerate(ROTONDE_VENDOR_ID, 0x00)
 			if err != nil {
 				time.Sleep(1 * time.Second)
 				continue
 			}
+			closeMissing(devices)
 
+			failed := false
 			for _, device := range devices {
 				if isOpen(device) {
 					continue
@@ -60,31 +264,67 @@ func StartHID(d *Dispatcher) {
 				if err != nil {
 					log.Warning(err)
 					log.Warningf("Failing device is: 0x%04x:0x%04x serial: %s", device.VendorId, device.ProductId, device.SerialNumber)
-					time.Sleep(1 * time.Second)
+					failed = true
 					continue
 				}
 				log.Infof("HID device successfully openned 0x%04x:0x%04x serial: %s", device.VendorId, device.ProductId, device.SerialNumber)
 
-				openned(device)
+				stop := openned(device)
 				go func() {
-					defer closed(device)
-					if err := startHIDConnection(device, cc, d); err != nil {
+					defer closed(device, stop)
+					if err := startHIDConnection(device, cc, d, stop); err != nil {
 						log.Warning(err)
 						time.Sleep(time.Second * 3)
 					}
 				}()
 			}
-			time.Sleep(1 * time.Second)
+
+			interval := pollInterval
+			if failed {
+				interval = retryInterval
+				if retryInterval *= 2; retryInterval > time.Second {
+					retryInterval = time.Second
+				}
+			} else {
+				retryInterval = HIDOpenRetryInterval
+			}
+
+			select {
+			case _, ok := <-hotplug: // nil channel when no watcher, blocks forever
+				if ok == false {
+					log.Warning("HID hotplug watcher stopped, falling back to polling")
+					hotplug = nil
+					pollInterval = 1 * time.Second
+				}
+			case <-time.After(interval):
+			}
 		}
 	}()
 
 	log.Infof("HID Listening for vendorId: 0x%04x", ROTONDE_VENDOR_ID)
 }
 
-func startHIDConnection(device *hid.DeviceInfo, cc *hid.Device, d *Dispatcher) error {
+// hidMetadata describes a HID device for the presence events
+func hidMetadata(device *hid.DeviceInfo) rotonde.Object {
+	return rotonde.Object{
+		"transport":    "hid",
+		"vendorId":     fmt.Sprintf("0x%04x", device.VendorId),
+		"productId":    fmt.Sprintf("0x%04x", device.ProductId),
+		"serial":       device.SerialNumber,
+		"manufacturer": device.Manufacturer,
+		"product":      device.Product,
+		"path":         device.Path,
+	}
+}
+
+func startHIDConnection(device *hid.DeviceInfo, cc *hid.Device, d *Dispatcher, stop chan struct{}) error {
 	defer cc.Close()
 
 	c := NewConnection()
+	c.Metadata = hidMetadata(device)
+	// devices speak the version 1, the filter only lets events, actions and
+	// undefs through, so they couldn't get the answer to a hello packet
+	c.Version = rotonde.MinProtocolVersion
 	d.AddConnection(c)
 	defer c.Close()
 
@@ -92,7 +332,17 @@ func startHIDConnection(device *hid.DeviceInfo, cc *hid.Device, d *Dispatcher) e
 		log.Warning(err)
 	}
 
-	errChan := make(chan error)
+	filter := newHIDFilter()
+	flow := newHIDFlowControl(cc)
+	link := &hidLink{cc: cc, stop: stop}
+
+	// the writer waits for credits, the packets are queued meanwhile
+	queue := make(chan interface{}, HIDQueueLength)
+	done := make(chan struct{})
+	defer close(done) // before c.Close
+	go queuePackets(c.InChan, queue, done)
+
+	errChan := make(chan error, 1) // buffered, the writer might already be gone when the reader fails
 	var connErr error
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -103,9 +353,9 @@ func startHIDConnection(device *hid.DeviceInfo, cc *hid.Device, d *Dispatcher) e
 		fixedLengthWriteBuffer[0] = 0x0
 		for {
 			select {
-			case dispatcherPacket := <-c.InChan:
-				if _, ok := dispatcherPacket.(rotonde.Definition); ok == true {
-					log.Info("USB skipping Definition messages")
+			case dispatcherPacket := <-queue:
+				if filter.wants(dispatcherPacket) == false {
+					log.Debug("USB skipping packet the device is not interested in")
 					continue
 				}
 				jsonPacket, err := rotonde.ToJSON(dispatcherPacket)
@@ -114,47 +364,28 @@ func startHIDConnection(device *hid.DeviceInfo, cc *hid.Device, d *Dispatcher) e
 					continue
 				}
 
-				first := true
-				currentOffset := 0
-				length := len(jsonPacket)
-				for currentOffset < length {
-					headerLength := 0
-					if first {
-						headerLength = HeaderLength
-					}
-					toWriteLength := length - currentOffset
-					// packet on the HID link can't be > MaxHIDFrameSize, split it if it's the case.
-					if toWriteLength > MaxHIDFrameSize-headerLength {
-						toWriteLength = MaxHIDFrameSize - headerLength
-					}
-
-					if first {
-						fixedLengthWriteBuffer[1] = 0x3c
-						fixedLengthWriteBuffer[2] = 0x40
-						fixedLengthWriteBuffer[3] = byte(length)
-						fixedLengthWriteBuffer[4] = byte(length >> 8)
-						first = false
-					}
-					copy(fixedLengthWriteBuffer[headerLength+1:], jsonPacket[currentOffset:currentOffset+toWriteLength])
-
-					n, err := cc.Write(fixedLengthWriteBuffer)
-					if err != nil {
+				if err := writeHIDPacket(link, flow, fixedLengthWriteBuffer, jsonPacket); err == errPacketTooLarge {
+					log.Warning(err)
+				} else if err != nil {
+					log.Warning(err)
+					// tell the device to drop the partially received packet and resync on the next frame start
+					if _, err := cc.SendFeatureReport([]byte{0x00, FEATURE_ABORT}); err != nil {
 						log.Warning(err)
-						break
-					}
-					if n > headerLength {
-						currentOffset += n - headerLength - 1
 					}
 				}
 
 			case connErr = <-errChan:
 				return
+			case <-stop:
+				// the reader notices stop after its current read times out
+				connErr = fmt.Errorf("HID device 0x%04x:0x%04x removed", device.VendorId, device.ProductId)
+				return
 			}
 		}
 	}()
 
 	wg.Add(1)
-	go frameReader(&wg, cc, c, errChan)
+	go frameReader(&wg, link, c, filter, errChan)
 
 	log.Info("Treating messages")
 	wg.Wait()
@@ -162,11 +393,111 @@ func startHIDConnection(device *hid.DeviceInfo, cc *hid.Device, d *Dispatcher) e
 	return connErr
 }
 
-func frameReader(wg *sync.WaitGroup, cc *hid.Device, c *Connection, errChan chan error) {
+// queuePackets moves the packets written by the dispatcher to queue until
+// done is closed, packets are dropped when queue is full, so a slow device
+// never blocks the dispatcher
+func queuePackets(in chan interface{}, queue chan interface{}, done chan struct{}) {
+	for {
+		select {
+		case packet, ok := <-in:
+			if ok == false {
+				return
+			}
+			select {
+			case queue <- packet:
+			default:
+				log.Warning("HID device not keeping up, dropping packet")
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// hidLink is the HID device seen as an io.ReadWriter of frames, so the framing
+// doesn't depend on a real device. Reads time out regularly, so a stopped
+// connection is closed promptly instead of waiting for a read error.
+type hidLink struct {
+	cc   *hid.Device
+	stop chan struct{}
+}
+
+func (link *hidLink) Read(p []byte) (int, error) {
+	for {
+		n, err := link.cc.ReadTimeout(p, HIDReadTimeout)
+		if err != nil || n > 0 {
+			return n, err
+		}
+		select {
+		case <-link.stop:
+			return 0, fmt.Errorf("HID connection stopped")
+		default:
+		}
+	}
+}
+
+func (link *hidLink) Write(p []byte) (int, error) {
+	return link.cc.Write(p)
+}
+
+// writeHIDPacket splits jsonPacket in MaxHIDFrameSize frames and writes them
+// to the device, the first frame carries the header. An error means that the
+// packet could not be completely sent, except for errPacketTooLarge which is
+// returned before anything is written.
+func writeHIDPacket(w io.Writer, flow *hidFlowControl, fixedLengthWriteBuffer []byte, jsonPacket []byte) error {
+	first := true
+	currentOffset := 0
+	length := len(jsonPacket)
+	if length > MaxHIDPacketSize {
+		return errPacketTooLarge
+	}
+	for currentOffset < length {
+		headerLength := 0
+		if first && length > 0xffff {
+			headerLength = ExtendedHeaderLength
+		} else if first {
+			headerLength = HeaderLength
+		}
+		toWriteLength := length - currentOffset
+		// packet on the HID link can't be > MaxHIDFrameSize, split it if it's the case.
+		if toWriteLength > MaxHIDFrameSize-headerLength {
+			toWriteLength = MaxHIDFrameSize - headerLength
+		}
+
+		if first && headerLength == ExtendedHeaderLength {
+			fixedLengthWriteBuffer[1] = 0x3c
+			fixedLengthWriteBuffer[2] = ExtendedFrameVersion
+			binary.LittleEndian.PutUint32(fixedLengthWriteBuffer[3:], uint32(length))
+			first = false
+		} else if first {
+			fixedLengthWriteBuffer[1] = 0x3c
+			fixedLengthWriteBuffer[2] = FrameVersion
+			binary.LittleEndian.PutUint16(fixedLengthWriteBuffer[3:], uint16(length))
+			first = false
+		}
+		copy(fixedLengthWriteBuffer[headerLength+1:], jsonPacket[currentOffset:currentOffset+toWriteLength])
+
+		if err := flow.acquire(); err != nil {
+			return err
+		}
+		n, err := w.Write(fixedLengthWriteBuffer)
+		if err != nil {
+			return err
+		}
+		if n > headerLength {
+			currentOffset += n - headerLength - 1
+		}
+	}
+	return nil
+}
+
+// frameReader reads the frames sent by the device, and writes the packets
+// they carry to the dispatcher connection
+func frameReader(wg *sync.WaitGroup, r io.Reader, c *Connection, filter *hidFilter, errChan chan error) {
 	defer wg.Done()
 	var buffer bytes.Buffer
 	var version uint8
-	var length uint16
+	var length uint32
 	var crc uint8
 	packet := make([]byte, MaxHIDFrameSize)
 
@@ -175,7 +506,7 @@ func frameReader(wg *sync.WaitGroup, cc *hid.Device, c *Connection, errChan chan
 			return nil
 		}
 		for buffer.Len() < n {
-			n, err := cc.Read(packet)
+			n, err := r.Read(packet)
 			if err != nil {
 				return err
 			}
@@ -208,7 +539,7 @@ func frameReader(wg *sync.WaitGroup, cc *hid.Device, c *Connection, errChan chan
 			return
 		}
 
-		if err := readNBytes(HeaderLength); err != nil {
+		if err := readNBytes(1); err != nil {
 			errChan <- err
 			return
 		}
@@ -216,9 +547,36 @@ func frameReader(wg *sync.WaitGroup, cc *hid.Device, c *Connection, errChan chan
 			errChan <- err
 			return
 		}
-		if err := binary.Read(&buffer, binary.LittleEndian, &length); err != nil {
-			errChan <- err
-			return
+		switch version {
+		case FrameVersion:
+			var shortLength uint16
+			if err := readNBytes(2); err != nil {
+				errChan <- err
+				return
+			}
+			if err := binary.Read(&buffer, binary.LittleEndian, &shortLength); err != nil {
+				errChan <- err
+				return
+			}
+			length = uint32(shortLength)
+		case ExtendedFrameVersion:
+			if err := readNBytes(4); err != nil {
+				errChan <- err
+				return
+			}
+			if err := binary.Read(&buffer, binary.LittleEndian, &length); err != nil {
+				errChan <- err
+				return
+			}
+		default:
+			log.Warningf("Unknown HID frame version 0x%02x, resyncing", version)
+			continue
+		}
+
+		if length > MaxHIDPacketSize {
+			// the body is left in the buffer, readUpToFrame skips it
+			log.Warning(errPacketTooLarge)
+			continue
 		}
 
 		if err := readNBytes(int(length)); err != nil {
@@ -239,9 +597,15 @@ func frameReader(wg *sync.WaitGroup, cc *hid.Device, c *Connection, errChan chan
 
 		dispatcherPacket, err := rotonde.FromJSON(bytes.NewReader(body))
 		if err != nil {
-			errChan <- fmt.Errorf("Failed to decode packet")
-			return
+			// the frame itself is valid, only this packet is dropped
+			log.Warning("Failed to decode packet: ", err)
+			continue
+		}
+		if _, ok := dispatcherPacket.(rotonde.Hello); ok {
+			log.Warning("Ignoring hello packet, HID devices speak the protocol version 1")
+			continue
 		}
+		filter.update(dispatcherPacket)
 		c.OutChan <- dispatcherPacket
 	}
 }
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HackerLoop/rotonde/shared"
+)
+
+// frameRecorder is an io.Writer recording the frames written to the device
+type frameRecorder struct {
+	frames [][]byte
+}
+
+func (recorder *frameRecorder) Write(p []byte) (int, error) {
+	recorder.frames = append(recorder.frames, append([]byte{}, p...))
+	return len(p), nil
+}
+
+// packet reassembles the packet carried by the recorded frames, and returns its header
+func (recorder *frameRecorder) packet(t *testing.T) (header []byte, body []byte) {
+	if len(recorder.frames) == 0 {
+		t.Fatal("No frame written")
+	}
+	first := recorder.frames[0]
+	if first[1] != 0x3c {
+		t.Fatalf("Expected the frame start byte, got 0x%02x", first[1])
+	}
+	var length int
+	var headerLength int
+	switch first[2] {
+	case FrameVersion:
+		headerLength = HeaderLength
+		length = int(binary.LittleEndian.Uint16(first[3:]))
+	case ExtendedFrameVersion:
+		headerLength = ExtendedHeaderLength
+		length = int(binary.LittleEndian.Uint32(first[3:]))
+	default:
+		t.Fatalf("Unknown frame version 0x%02x", first[2])
+	}
+
+	var data []byte
+	data = append(data, first[1+headerLength:]...)
+	for _, frame := range recorder.frames[1:] {
+		data = append(data, frame[1:]...)
+	}
+	if len(data) < length {
+		t.Fatalf("Expected %d bytes, got %d", length, len(data))
+	}
+	return first[1 : 1+headerLength], data[:length]
+}
+
+func hidEvent(size int) []byte {
+	jsonPacket, _ := rotonde.ToJSON(rotonde.Event{Identifier: "BLOB", Data: rotonde.Object{"blob": strings.Repeat("a", size)}})
+	return jsonPacket
+}
+
+func TestWriteHIDPacket(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		version byte
+	}{
+		{"single frame", 10, FrameVersion},
+		{"multiple frames", 1000, FrameVersion},
+		{"extended header", 70000, ExtendedFrameVersion},
+	}
+	for _, test := range tests {
+		recorder := &frameRecorder{}
+		jsonPacket := hidEvent(test.size)
+		if err := writeHIDPacket(recorder, &hidFlowControl{}, make([]byte, MaxHIDFrameSize+1), jsonPacket); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		for _, frame := range recorder.frames {
+			if len(frame) != MaxHIDFrameSize+1 {
+				t.Errorf("%s: expected frames of %d bytes, got %d", test.name, MaxHIDFrameSize+1, len(frame))
+			}
+		}
+		header, body := recorder.packet(t)
+		if header[1] != test.version {
+			t.Errorf("%s: expected frame version 0x%02x, got 0x%02x", test.name, test.version, header[1])
+		}
+		if bytes.Equal(body, jsonPacket) == false {
+			t.Errorf("%s: the reassembled packet differs from the written one", test.name)
+		}
+	}
+}
+
+func TestWriteHIDPacketTooLarge(t *testing.T) {
+	recorder := &frameRecorder{}
+	err := writeHIDPacket(recorder, &hidFlowControl{}, make([]byte, MaxHIDFrameSize+1), make([]byte, MaxHIDPacketSize+1))
+	if err != errPacketTooLarge {
+		t.Errorf("Expected errPacketTooLarge, got %v", err)
+	}
+	if len(recorder.frames) != 0 {
+		t.Errorf("Expected nothing to be written, got %d frames", len(recorder.frames))
+	}
+}
+
+// hidFrame encodes a packet as sent by the devices: header, body and crc
+func hidFrame(version byte, length int, body []byte) []byte {
+	frame := []byte{0x3c, version}
+	if version == ExtendedFrameVersion {
+		frame = append(frame, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(frame[2:], uint32(length))
+	} else {
+		frame = append(frame, 0, 0)
+		binary.LittleEndian.PutUint16(frame[2:], uint16(length))
+	}
+	frame = append(frame, body...)
+	return append(frame, 0x00) // crc, not checked
+}
+
+// readHIDPackets runs a frameReader on stream, and returns the packets it
+// sent to the dispatcher once the stream is exhausted
+func readHIDPackets(t *testing.T, stream []byte) []interface{} {
+	c := NewConnection()
+	errChan := make(chan error, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go frameReader(&wg, bytes.NewReader(stream), c, newHIDFilter(), errChan)
+	wg.Wait()
+	if err := <-errChan; err == nil {
+		t.Fatal("Expected the end of the stream to be reported")
+	}
+	close(c.OutChan)
+
+	var packets []interface{}
+	for packet := range c.OutChan {
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+func TestFrameReader(t *testing.T) {
+	small := hidEvent(10)
+	large := hidEvent(70000)
+
+	var stream []byte
+	stream = append(stream, hidFrame(FrameVersion, len(small), small)...)
+	stream = append(stream, hidFrame(ExtendedFrameVersion, len(large), large)...)
+	// oversize packet, its header is skipped, and the reader resyncs on the next frame
+	stream = append(stream, hidFrame(ExtendedFrameVersion, MaxHIDPacketSize+1, []byte("garbage"))...)
+	stream = append(stream, hidFrame(FrameVersion, len(small), small)...)
+
+	packets := readHIDPackets(t, stream)
+	if len(packets) != 3 {
+		t.Fatalf("Expected 3 packets, got %d", len(packets))
+	}
+	for i, expected := range []int{10, 70000, 10} {
+		event, ok := packets[i].(rotonde.Event)
+		if ok == false || len(event.Data["blob"].(string)) != expected {
+			t.Errorf("Packet %d: expected an event carrying %d bytes, got %+v", i, expected, packets[i])
+		}
+	}
+}
+
+// testReporter answers the FEATURE_CREDITS reports with report, or with err
+type testReporter struct {
+	report []byte
+	err    error
+}
+
+func (reporter *testReporter) SendFeatureReport(b []byte) (int, error) {
+	return len(b), reporter.err
+}
+
+func (reporter *testReporter) GetFeatureReport(b []byte) (int, error) {
+	if reporter.err != nil {
+		return 0, reporter.err
+	}
+	return copy(b, reporter.report), nil
+}
+
+func TestHIDFlowControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		report  []byte
+		enabled bool
+		credits int
+	}{
+		{"credits", []byte{0x00, CreditsMarker, 4}, true, 4},
+		{"legacy firmware answering zeros", []byte{0x00, 0x00, 0x00}, false, 0},
+		{"short report", []byte{0x00, CreditsMarker}, false, 0},
+	}
+	for _, test := range tests {
+		flow := newHIDFlowControl(&testReporter{report: test.report})
+		if flow.enabled != test.enabled || flow.credits != test.credits {
+			t.Errorf("%s: expected enabled %v with %d credits, got %v with %d", test.name, test.enabled, test.credits, flow.enabled, flow.credits)
+		}
+	}
+}
+
+func TestHIDFlowControlTimeout(t *testing.T) {
+	reporter := &testReporter{report: []byte{0x00, CreditsMarker, 1}}
+	flow := newHIDFlowControl(reporter)
+	flow.timeout = 50 * time.Millisecond
+	if err := flow.acquire(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the device grants no credit in time, then stops answering
+	reporter.report = []byte{0x00, CreditsMarker, 0}
+	if err := flow.acquire(); err == nil {
+		t.Fatal("Expected the packet to be aborted on timeout")
+	}
+	reporter.err = fmt.Errorf("broken pipe")
+	if err := flow.acquire(); err == nil {
+		t.Fatal("Expected the packet to be aborted on error")
+	}
+	if flow.enabled == false {
+		t.Fatal("Expected flow control to stay enabled")
+	}
+
+	// the following packets wait for credits again
+	reporter.err = nil
+	reporter.report = []byte{0x00, CreditsMarker, 2}
+	if err := flow.acquire(); err != nil {
+		t.Errorf("Expected the credits to be granted, got %v", err)
+	}
+}
+
+func TestQueuePackets(t *testing.T) {
+	in := make(chan interface{}, 3)
+	queue := make(chan interface{}, 1)
+	done := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		in <- rotonde.Event{Identifier: fmt.Sprint("EVENT_", i)}
+	}
+	close(in)
+
+	// the queue is never read, like when the writer waits for credits
+	finished := make(chan struct{})
+	go func() {
+		queuePackets(in, queue, done)
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queuePackets blocked on a full queue")
+	}
+	if event := (<-queue).(rotonde.Event); event.Identifier != "EVENT_0" || len(queue) != 0 {
+		t.Errorf("Expected only the first packet to be queued, got %s and %d more", event.Identifier, len(queue))
+	}
+}
+
+func TestHIDFilter(t *testing.T) {
+	filter := newHIDFilter()
+	filter.update(rotonde.Subscription{Identifier: "BUTTON"})
+	filter.update(rotonde.Subscription{Identifier: "SWITCH"})
+	filter.update(rotonde.Unsubscription{Identifier: "SWITCH"})
+	filter.update(rotonde.Definition{Identifier: "LED", Type: "action"})
+
+	tests := []struct {
+		packet interface{}
+		wanted bool
+	}{
+		// routed by the dispatcher, whatever the filter knows
+		{rotonde.Event{Identifier: "TEMPERATURE"}, true},
+		{rotonde.Action{Identifier: "MOTOR"}, true},
+		{rotonde.Definition{Identifier: "BUTTON", Type: "event"}, false},
+		{rotonde.UnDefinition{Identifier: "BUTTON", Type: "event"}, true},
+		{rotonde.UnDefinition{Identifier: "LED", Type: "action"}, true},
+		{rotonde.UnDefinition{Identifier: "SWITCH", Type: "event"}, false},
+		{rotonde.UnDefinition{Identifier: "TEMPERATURE", Type: "event"}, false},
+	}
+	for _, test := range tests {
+		if filter.wants(test.packet) != test.wanted {
+			t.Errorf("%#v: expected wanted %v", test.packet, test.wanted)
+		}
+	}
+}
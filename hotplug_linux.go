@@ -0,0 +1,65 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// watchHotplug listens for kernel uevents on a netlink socket, and
+// notifies the returned channel each time a hidraw device is added or removed.
+func watchHotplug() (<-chan string, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	events := make(chan string, 10)
+	go func() {
+		defer syscall.Close(fd)
+		defer close(events)
+
+		buffer := make([]byte, 4096)
+		for {
+			n, err := syscall.Read(fd, buffer)
+			if err != nil {
+				log.Warning(err)
+				return
+			}
+			if action, ok := parseHidrawUEvent(buffer[:n]); ok {
+				log.Debug("HID hotplug event ", action)
+				select {
+				case events <- action:
+				default: // an enumeration is already pending
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// parseHidrawUEvent returns the action of a uevent message if its subsystem is hidraw.
+// uevent messages are a header (ACTION@DEVPATH) followed by null separated KEY=VALUE pairs.
+func parseHidrawUEvent(message []byte) (string, bool) {
+	var action string
+	isHidraw := false
+	for _, field := range bytes.Split(message, []byte{0}) {
+		if bytes.HasPrefix(field, []byte("ACTION=")) {
+			action = string(field[len("ACTION="):])
+		} else if bytes.Equal(field, []byte("SUBSYSTEM=hidraw")) {
+			isHidraw = true
+		}
+	}
+	if isHidraw == false || (action != "add" && action != "remove") {
+		return "", false
+	}
+	return action, true
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// watchHotplug is only implemented on linux, other platforms rely on polling
+func watchHotplug() (<-chan string, error) {
+	return nil, errors.New("hotplug events not supported on this platform")
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/HackerLoop/rotonde/shared"
+	log "github.com/Sirupsen/logrus"
+)
+
+// GatewayTimeout is how long an HTTP request waits for its dispatcher connection to be added
+const GatewayTimeout = 5 * time.Second
+
+// registerHTTPGateway adds the HTTP endpoints for clients that can't hold a
+// websocket open, each request is backed by a short-lived dispatcher connection:
+//
+//	POST /actions/{identifier}  the body is the data of the action
+//	POST /events/{identifier}   the body is the data of the event
+//	GET  /definitions           returns the available definitions
+//	GET  /events?sub=ID1,ID2    streams the subscribed events (Server-Sent Events)
+//	GET  /schemas               returns the JSON Schemas of the available definitions
+//	GET  /schemas/{identifier}  returns the JSON Schema of a definition
+func registerHTTPGateway(d *Dispatcher, options WebsocketOptions) {
+	http.HandleFunc("/actions/", func(w http.ResponseWriter, r *http.Request) {
+		handleGatewayPost(w, r, d, options, "/actions/")
+	})
+	http.HandleFunc("/events/", func(w http.ResponseWriter, r *http.Request) {
+		handleGatewayPost(w, r, d, options, "/events/")
+	})
+	http.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		handleEventStream(w, r, d, options)
+	})
+	http.HandleFunc("/schemas", func(w http.ResponseWriter, r *http.Request) {
+		handleSchemas(w, r, d, options)
+	})
+	http.HandleFunc("/schemas/", func(w http.ResponseWriter, r *http.Request) {
+		handleSchemas(w, r, d, options)
+	})
+	http.HandleFunc("/definitions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		identity, err := gatewayIdentity(r, options)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		c, definitions, err := addGatewayConnection(d, identity)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		c.Close()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(definitions); err != nil {
+			log.Warning(err)
+		}
+	})
+}
+
+func handleGatewayPost(w http.ResponseWriter, r *http.Request, d *Dispatcher, options WebsocketOptions, prefix string) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	identifier := strings.TrimPrefix(r.URL.Path, prefix)
+	if identifier == "" || strings.Contains(identifier, "/") {
+		http.Error(w, "Invalid identifier", http.StatusNotFound)
+		return
+	}
+	identity, err := gatewayIdentity(r, options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	data := rotonde.Object{}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("Invalid data: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	var packet interface{}
+	if prefix == "/actions/" {
+		packet = rotonde.Action{Identifier: identifier, Data: data}
+	} else {
+		packet = rotonde.Event{Identifier: identifier, Data: data}
+	}
+
+	// the dispatcher would drop the packet silently, d.acl is set before the
+	// dispatcher starts and never changes
+	if d.acl != nil && d.acl.Allows(identity, packet) == false {
+		http.Error(w, fmt.Sprintf("Not allowed to send %s", identifier), http.StatusForbidden)
+		return
+	}
+
+	c, definitions, err := addGatewayConnection(d, identity)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if definition, err := definitions.GetDefinitionForIdentifier(identifier); err == nil {
+		if _, err := definition.Validate(data); err != nil {
+			c.Close()
+			http.Error(w, fmt.Sprintf("Invalid data: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	// the dispatcher still receives the packet after the channel is closed
+	c.OutChan <- packet
+	c.Close()
+
+	// accepted for delivery, the modules handle it asynchronously
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func handleSchemas(w http.ResponseWriter, r *http.Request, d *Dispatcher, options WebsocketOptions) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	identity, err := gatewayIdentity(r, options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	c, definitions, err := addGatewayConnection(d, identity)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	c.Close()
+
+	var response interface{} = definitions.JSONSchemas()
+	if identifier := strings.TrimPrefix(r.URL.Path, "/schemas/"); identifier != r.URL.Path && identifier != "" {
+		definition, err := definitions.GetDefinitionForIdentifier(identifier)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		response = definition.JSONSchema()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Warning(err)
+	}
+}
+
+// handleEventStream registers a read-only connection subscribed to the
+// identifiers of the sub parameter, and streams the events it receives.
+// Events don't carry sequence numbers, so no id is sent and Last-Event-ID is
+// not supported, clients that reconnect only get the events sent after.
+func handleEventStream(w http.ResponseWriter, r *http.Request, d *Dispatcher, options WebsocketOptions) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if ok == false {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	identifiers := splitList(r.URL.Query().Get("sub"))
+	if len(identifiers) == 0 {
+		http.Error(w, "Missing sub parameter", http.StatusBadRequest)
+		return
+	}
+	identity, err := gatewayIdentity(r, options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	c, _, err := addGatewayConnection(d, identity)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer c.Close()
+	for _, identifier := range identifiers {
+		c.OutChan <- rotonde.Subscription{Identifier: identifier}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var pings <-chan time.Time // nil when pings are disabled, never fires
+	if options.PingInterval > 0 {
+		ticker := time.NewTicker(options.PingInterval)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+
+	log.Info("Streaming events ", identifiers)
+	for {
+		select {
+		case dispatcherPacket := <-c.InChan:
+			if _, ok := dispatcherPacket.(rotonde.Event); ok == false {
+				continue
+			}
+			jsonPacket, err := rotonde.ToJSON(dispatcherPacket)
+			if err != nil {
+				log.Warning(err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", jsonPacket); err != nil {
+				log.Warning(err)
+				return
+			}
+			flusher.Flush()
+		case <-pings:
+			// comment line, keeps proxies from closing the stream and detects dead clients
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				log.Warning(err)
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			log.Info("Event stream closed")
+			return
+		}
+	}
+}
+
+// gatewayIdentity authenticates the request when authentication is enabled
+func gatewayIdentity(r *http.Request, options WebsocketOptions) (string, error) {
+	if options.Authenticator == nil {
+		return "", nil
+	}
+	return options.Authenticator.Authenticate(tokenFromRequest(r))
+}
+
+// addGatewayConnection adds a connection to the dispatcher and waits for it
+// to be added, returns the definitions received in the meantime.
+func addGatewayConnection(d *Dispatcher, identity string) (*Connection, rotonde.Definitions, error) {
+	c := NewConnection()
+	c.Identity = identity
+	d.AddConnection(c)
+
+	definitions := rotonde.Definitions{}
+	collect := func(packet interface{}) {
+		if definition, ok := packet.(rotonde.Definition); ok {
+			definitions = append(definitions, &definition)
+		}
+	}
+
+	timeout := time.After(GatewayTimeout)
+	for {
+		select {
+		case packet := <-c.InChan:
+			collect(packet)
+		case <-c.Added():
+			for {
+				select {
+				case packet := <-c.InChan:
+					collect(packet)
+				default:
+					return c, definitions, nil
+				}
+			}
+		case <-timeout:
+			c.Close()
+			return nil, nil, fmt.Errorf("Timeout waiting for the dispatcher")
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/HackerLoop/rotonde/shared"
+)
+
+func TestGatewayPost(t *testing.T) {
+	d := NewDispatcher()
+	d.SetACL(&ACL{Modules: map[string]*ACLRules{
+		"*": {Define: []string{"*"}, Send: []string{"LED"}},
+	}})
+	go d.Start()
+
+	watcher := NewConnection()
+	d.AddConnection(watcher)
+	module := NewConnection()
+	d.AddConnection(module)
+	module.OutChan <- rotonde.Definition{Identifier: "LED", Type: "action", Fields: rotonde.FieldDefinitions{{Name: "level", Type: "number", Required: true}}}
+	waitForDefinition(t, watcher, "LED")
+
+	tests := []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{"/actions/LED", `{"level": 1}`, http.StatusAccepted},
+		{"/actions/LED", `{}`, http.StatusBadRequest},
+		{"/actions/LED", `{"level": "high"}`, http.StatusBadRequest},
+		{"/actions/SWITCH", `{}`, http.StatusForbidden},
+		{"/events/LED", `not json`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("POST", test.path, strings.NewReader(test.body))
+		prefix := "/actions/"
+		if strings.HasPrefix(test.path, "/events/") {
+			prefix = "/events/"
+		}
+		handleGatewayPost(recorder, request, d, WebsocketOptions{}, prefix)
+		if recorder.Code != test.status {
+			t.Errorf("POST %s %s: expected %d, got %d %s", test.path, test.body, test.status, recorder.Code, recorder.Body)
+		}
+	}
+
+	// the accepted action reaches the module
+	action, ok := receive(t, module).(rotonde.Action)
+	if ok == false || action.Identifier != "LED" || action.Data["level"] != 1.0 {
+		t.Errorf("Expected the LED action, got %+v", action)
+	}
+}
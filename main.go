@@ -2,21 +2,140 @@ package main
 
 import (
 	"flag"
+	"os"
 	"runtime"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
 )
 
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
+	if len(os.Args) > 1 && os.Args[1] == "gen" {
+		if err := runGen(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	port := flag.Int("port", 4224, "port the websocket will listen on")
+	tlsCert := flag.String("tls-cert", "", "certificate file, enables TLS on the websocket (wss://) with -tls-key")
+	tlsKey := flag.String("tls-key", "", "private key file of the -tls-cert certificate")
+	tlsClientCA := flag.String("tls-client-ca", "", "CA file, when set clients have to present a certificate signed by this CA")
+	authTokens := flag.String("auth-tokens", "", "JSON file mapping tokens to module identities, enables websocket authentication")
+	aclFile := flag.String("acl", "", "JSON file listing the identifiers each module may define, send or subscribe to")
+	allowedOrigins := flag.String("allowed-origins", "", "comma separated list of origins allowed to connect to the websocket, all when empty")
+	maxMessageSize := flag.Int64("max-message-size", 4*1024*1024, "maximum size in bytes of a websocket message")
+	bufferSize := flag.Int("buffer-size", 2048, "size in bytes of the websocket read and write buffers")
+	writeTimeout := flag.Duration("write-timeout", 10*time.Second, "time allowed to write a message to a websocket peer")
+	idleTimeout := flag.Duration("idle-timeout", 0, "close websocket peers that send nothing for this long, 0 disables it")
+	pingInterval := flag.Duration("ping-interval", 30*time.Second, "interval between the pings sent to websocket peers, 0 disables pings")
+	pongTimeout := flag.Duration("pong-timeout", 60*time.Second, "websocket peers that don't answer pings for this long are disconnected")
+	compression := flag.Bool("compression", false, "negotiate permessage-deflate compression with websocket clients")
+	compressionThreshold := flag.Int("compression-threshold", 1024, "minimum size in bytes of the websocket messages to compress")
+	batchSize := flag.Int("batch-size", 0, "maximum number of queued events and actions coalesced in a batch packet for websocket peers that support it, 0 disables batching")
+	endpointsFile := flag.String("endpoints", "", "JSON file configuring the websocket mount points, a single endpoint on / when empty")
+	mqttFile := flag.String("mqtt", "", "JSON file configuring the MQTT bridge, disabled when empty")
+	hostname, _ := os.Hostname()
+	instance := flag.String("instance", hostname, "name of this instance, prefixes its identifiers on federated instances")
+	federate := flag.String("federate", "", "comma separated list of name=ws://host:port/ rotonde instances to federate with")
+	moduleNames := flag.String("modules", "", "comma separated list of the built-in modules to start")
 	flag.Parse()
 
+	var authenticator *Authenticator
+	if *authTokens != "" {
+		var err error
+		if authenticator, err = LoadAuthenticator(*authTokens); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	endpoints := DefaultEndpoints(authenticator)
+	if *endpointsFile != "" {
+		var err error
+		if endpoints, err = LoadEndpoints(*endpointsFile, authenticator); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *pingInterval > 0 && *pongTimeout <= *pingInterval {
+		log.Fatal("-pong-timeout has to be greater than -ping-interval")
+	}
+
 	d := NewDispatcher()
+	if *aclFile != "" {
+		acl, err := LoadACL(*aclFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		d.SetACL(acl)
+	}
+
+	links, err := ParseFederationLinks(*federate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(links) > 0 && (*instance == "" || strings.Contains(*instance, FederationSeparator)) {
+		log.Fatalf("-instance has to be set, without %s, to federate", FederationSeparator)
+	}
+	for _, link := range links {
+		go StartFederation(d, *instance, link)
+	}
+
+	if err := StartModules(d, splitList(*moduleNames)); err != nil {
+		log.Fatal(err)
+	}
 
 	go StartHID(d)
-	go StartWebsocket(d, *port)
+	if *mqttFile != "" {
+		mqttConfig, err := LoadMQTTConfig(*mqttFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go StartMQTT(d, mqttConfig)
+	}
+	go StartWebsocket(d, WebsocketOptions{
+		Port:        *port,
+		TLSCert:     *tlsCert,
+		TLSKey:      *tlsKey,
+		TLSClientCA: *tlsClientCA,
+
+		Authenticator: authenticator,
+		Endpoints:     endpoints,
+
+		AllowedOrigins:  splitList(*allowedOrigins),
+		ReadBufferSize:  *bufferSize,
+		WriteBufferSize: *bufferSize,
+		MaxMessageSize:  *maxMessageSize,
+		WriteTimeout:    *writeTimeout,
+		IdleTimeout:     *idleTimeout,
+
+		PingInterval: *pingInterval,
+		PongTimeout:  *pongTimeout,
+
+		EnableCompression:    *compression,
+		CompressionThreshold: *compressionThreshold,
+
+		BatchSize: *batchSize,
+
+		Instance:        *instance,
+		FederationLinks: links,
+	})
 
 	go d.Start()
 
 	select {}
 }
+
+// splitList splits a comma separated flag value, returns nil for an empty value
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
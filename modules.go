@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Module is a module running inside the rotonde binary, Start is called in
+// its own goroutine with a connection already added to the dispatcher,
+// packets are exchanged with the InChan and OutChan of the connection,
+// without any serialization.
+type Module interface {
+	Start(connection *Connection)
+}
+
+var modules = map[string]Module{}
+
+// RegisterModule makes a module available to the -modules option, it is
+// meant to be called from the init function of the file declaring the module.
+func RegisterModule(name string, module Module) {
+	if _, ok := modules[name]; ok {
+		log.Fatalf("Module %s registered twice", name)
+	}
+	modules[name] = module
+}
+
+// ModuleNames returns the names of the registered modules
+func ModuleNames() []string {
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StartModules starts the given modules, the name of the module is the
+// identity of its connection, for the ACL.
+func StartModules(d *Dispatcher, names []string) error {
+	for _, name := range names {
+		if _, ok := modules[name]; ok == false {
+			return fmt.Errorf("Unknown module %s, available modules: %v", name, ModuleNames())
+		}
+	}
+
+	for _, name := range names {
+		c := NewConnection()
+		c.Identity = name
+		d.AddConnection(c)
+		go modules[name].Start(c)
+		log.Info("Started module ", name)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/HackerLoop/rotonde/shared"
+)
+
+func init() {
+	RegisterModule("schema", new(SchemaModule))
+}
+
+// SchemaModule answers the ROTONDE_GET_SCHEMAS action with a ROTONDE_SCHEMAS
+// event carrying the JSON Schemas of the available definitions, or of the
+// definition of the action identifier field when given.
+type SchemaModule struct{}
+
+func (module *SchemaModule) Start(connection *Connection) {
+	get := rotonde.Definition{Identifier: "ROTONDE_GET_SCHEMAS", Type: "action"}
+	get.PushField("identifier", "string", "")
+	connection.OutChan <- get
+
+	event := rotonde.Definition{Identifier: "ROTONDE_SCHEMAS", Type: "event"}
+	event.PushField("schemas", "", "")
+	connection.OutChan <- event
+
+	// the dispatcher sends all the other definitions and undefinitions to every connection
+	definitions := rotonde.Definitions{&get, &event}
+	for dispatcherPacket := range connection.InChan {
+		switch data := dispatcherPacket.(type) {
+		case rotonde.Definition:
+			definitions = rotonde.PushDefinition(definitions, &data)
+		case rotonde.UnDefinition:
+			definitions = rotonde.RemoveDefinition(definitions, data.Identifier)
+		case rotonde.Action:
+			if data.Identifier != "ROTONDE_GET_SCHEMAS" {
+				continue
+			}
+			var schemas []rotonde.Object
+			if identifier, ok := data.Data["identifier"].(string); ok && identifier != "" {
+				if definition, err := definitions.GetDefinitionForIdentifier(identifier); err == nil {
+					schemas = append(schemas, definition.JSONSchema())
+				}
+			} else {
+				schemas = definitions.JSONSchemas()
+			}
+			connection.OutChan <- rotonde.Event{Identifier: "ROTONDE_SCHEMAS", Data: rotonde.Object{"schemas": schemas}}
+		}
+	}
+}
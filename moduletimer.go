@@ -0,0 +1,42 @@
+package main
+
+import (
+	"time"
+
+	"github.com/HackerLoop/rotonde/shared"
+	log "github.com/Sirupsen/logrus"
+)
+
+func init() {
+	RegisterModule("timer", new(TimerModule))
+}
+
+// TimerModule sends a TIMER_FIRED event when the delay of a TIMER_START action expires
+type TimerModule struct{}
+
+func (module *TimerModule) Start(connection *Connection) {
+	start := rotonde.Definition{Identifier: "TIMER_START", Type: "action"}
+	start.PushField("name", "string", "")
+	start.PushField("delay", "number", "ms")
+	connection.OutChan <- start
+
+	fired := rotonde.Definition{Identifier: "TIMER_FIRED", Type: "event"}
+	fired.PushField("name", "string", "")
+	connection.OutChan <- fired
+
+	for dispatcherPacket := range connection.InChan {
+		action, ok := dispatcherPacket.(rotonde.Action)
+		if ok == false || action.Identifier != "TIMER_START" {
+			continue
+		}
+		delay, ok := rotonde.ToFloat(action.Data["delay"])
+		if ok == false || delay < 0 {
+			log.Warning("TIMER_START needs a positive delay")
+			continue
+		}
+		name := action.Data["name"]
+		time.AfterFunc(time.Duration(delay)*time.Millisecond, func() {
+			connection.OutChan <- rotonde.Event{Identifier: "TIMER_FIRED", Data: rotonde.Object{"name": name}}
+		})
+	}
+}
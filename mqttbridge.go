@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/HackerLoop/rotonde/shared"
+	log "github.com/Sirupsen/logrus"
+	"github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTTEventMapping maps the messages published on an MQTT topic pattern to a rotonde event
+type MQTTEventMapping struct {
+	Topic      string                   `json:"topic"` // MQTT topic filter, + and # wildcards allowed
+	Identifier string                   `json:"identifier"`
+	Fields     rotonde.FieldDefinitions `json:"fields"` // fields of the JSON payloads, exposed in the definition
+}
+
+// MQTTActionMapping publishes the rotonde actions with the identifier on an MQTT topic
+type MQTTActionMapping struct {
+	Identifier string                   `json:"identifier"`
+	Topic      string                   `json:"topic"`
+	Fields     rotonde.FieldDefinitions `json:"fields"`
+}
+
+// MQTTConfig configures the MQTT bridge, loaded from a JSON file of the form:
+//
+//	{
+//	  "broker": "tcp://localhost:1883",
+//	  "clientId": "rotonde",
+//	  "events": [
+//	    {"topic": "sensors/+/temperature", "identifier": "TEMPERATURE", "fields": [{"name": "value", "type": "number", "units": "celsius"}]}
+//	  ],
+//	  "actions": [
+//	    {"identifier": "SET_LIGHT", "topic": "lights/set", "fields": [{"name": "on", "type": "boolean"}]}
+//	  ]
+//	}
+type MQTTConfig struct {
+	Broker   string `json:"broker"`
+	ClientID string `json:"clientId"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	QOS      byte   `json:"qos"`
+
+	Events  []MQTTEventMapping  `json:"events"`
+	Actions []MQTTActionMapping `json:"actions"`
+}
+
+// LoadMQTTConfig reads the MQTT bridge configuration file
+func LoadMQTTConfig(path string) (*MQTTConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &MQTTConfig{ClientID: "rotonde"}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("Could not parse %s: %s", path, err)
+	}
+	if config.Broker == "" {
+		return nil, fmt.Errorf("No broker found in %s", path)
+	}
+	if config.QOS > 2 {
+		return nil, fmt.Errorf("Invalid qos %d in %s", config.QOS, path)
+	}
+	for _, mapping := range config.Events {
+		if mapping.Topic == "" || mapping.Identifier == "" {
+			return nil, fmt.Errorf("Events need a topic and an identifier in %s", path)
+		}
+	}
+	for _, mapping := range config.Actions {
+		if mapping.Topic == "" || mapping.Identifier == "" {
+			return nil, fmt.Errorf("Actions need a topic and an identifier in %s", path)
+		}
+	}
+	return config, nil
+}
+
+// StartMQTT connects to the broker and adds the bridge as a connection to the
+// dispatcher, MQTT messages matching the event mappings are sent as events,
+// and the actions of the action mappings are published.
+// The connection is only added once the broker is reachable, nothing would
+// drain its InChan before, which would block the dispatcher.
+func StartMQTT(d *Dispatcher, config *MQTTConfig) {
+	c := NewConnection()
+
+	options := mqtt.NewClientOptions()
+	options.AddBroker(config.Broker)
+	options.SetClientID(config.ClientID)
+	options.SetUsername(config.Username)
+	options.SetPassword(config.Password)
+	options.SetAutoReconnect(true)
+	options.SetMaxReconnectInterval(30 * time.Second)
+	options.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		log.Warning("MQTT connection lost: ", err)
+	})
+	// subscriptions are done on each (re)connection
+	options.SetOnConnectHandler(func(client mqtt.Client) {
+		log.Infof("MQTT connected to %s", config.Broker)
+		for _, mapping := range config.Events {
+			mapping := mapping
+			token := client.Subscribe(mapping.Topic, config.QOS, func(client mqtt.Client, message mqtt.Message) {
+				c.OutChan <- mqttEvent(mapping, message)
+			})
+			if token.Wait() && token.Error() != nil {
+				log.Warning(token.Error())
+			}
+		}
+	})
+
+	client := mqtt.NewClient(options)
+	for {
+		token := client.Connect()
+		if token.Wait() && token.Error() == nil {
+			break
+		}
+		log.Warning("MQTT connection failed: ", token.Error())
+		time.Sleep(5 * time.Second)
+	}
+
+	d.AddConnection(c)
+	for _, mapping := range config.Events {
+		definition := rotonde.Definition{Identifier: mapping.Identifier, Type: "event", Fields: mapping.Fields}
+		definition.PushField("topic", "string", "")
+		c.OutChan <- definition
+	}
+	for _, mapping := range config.Actions {
+		c.OutChan <- rotonde.Definition{Identifier: mapping.Identifier, Type: "action", Fields: mapping.Fields}
+	}
+
+	for dispatcherPacket := range c.InChan {
+		action, ok := dispatcherPacket.(rotonde.Action)
+		if ok == false {
+			continue
+		}
+		for _, mapping := range config.Actions {
+			if mapping.Identifier != action.Identifier {
+				continue
+			}
+			payload, err := json.Marshal(action.Data)
+			if err != nil {
+				log.Warning(err)
+				continue
+			}
+			client.Publish(mapping.Topic, config.QOS, false, payload)
+		}
+	}
+}
+
+// mqttEvent converts an MQTT message to a rotonde event, JSON object payloads
+// are used as data, other JSON payloads (numbers, booleans, ...) are given in
+// the value field with their JSON type, and non JSON payloads as a string.
+// The topic the message was published on is added to the data.
+func mqttEvent(mapping MQTTEventMapping, message mqtt.Message) rotonde.Event {
+	var payload interface{}
+	if err := json.Unmarshal(message.Payload(), &payload); err != nil {
+		payload = string(message.Payload())
+	}
+	data, ok := payload.(map[string]interface{})
+	if ok == false {
+		data = rotonde.Object{"value": payload}
+	}
+	data["topic"] = message.Topic()
+	return rotonde.Event{Identifier: mapping.Identifier, Data: data}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HackerLoop/rotonde/shared"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// testMessage is an mqtt.Message with only a topic and a payload
+type testMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (message testMessage) Duplicate() bool   { return false }
+func (message testMessage) Qos() byte         { return 0 }
+func (message testMessage) Retained() bool    { return false }
+func (message testMessage) Topic() string     { return message.topic }
+func (message testMessage) MessageID() uint16 { return 0 }
+func (message testMessage) Payload() []byte   { return message.payload }
+func (message testMessage) Ack()              {}
+
+func TestMQTTEvent(t *testing.T) {
+	mapping := MQTTEventMapping{
+		Topic:      "sensors/+/temperature",
+		Identifier: "TEMPERATURE",
+		Fields:     rotonde.FieldDefinitions{{Name: "value", Type: "number", Units: "celsius"}},
+	}
+	definition := rotonde.Definition{Identifier: mapping.Identifier, Type: "event", Fields: mapping.Fields}
+
+	tests := []struct {
+		payload string
+		data    rotonde.Object
+		valid   bool
+	}{
+		{`21.5`, rotonde.Object{"value": 21.5}, true},
+		{`{"value": 21.5}`, rotonde.Object{"value": 21.5}, true},
+		{`true`, rotonde.Object{"value": true}, false},
+		{`"warm"`, rotonde.Object{"value": "warm"}, false},
+		{`not json`, rotonde.Object{"value": "not json"}, false},
+	}
+	for _, test := range tests {
+		event := mqttEvent(mapping, testMessage{topic: "sensors/kitchen/temperature", payload: []byte(test.payload)})
+		test.data["topic"] = "sensors/kitchen/temperature"
+		if event.Identifier != mapping.Identifier || reflect.DeepEqual(event.Data, test.data) == false {
+			t.Errorf("%s: expected %v, got %v", test.payload, test.data, event.Data)
+		}
+		if _, err := definition.Validate(event.Data); (err == nil) != test.valid {
+			t.Errorf("%s: unexpected validation result %v", test.payload, err)
+		}
+	}
+}
+
+// testBroker is a minimal MQTT broker, enough for the bridge: it accepts
+// connections, records subscriptions, and forwards QoS 0 publishes to the
+// matching subscribers. Published messages are also sent to its published chan.
+type testBroker struct {
+	listener  net.Listener
+	mutex     sync.Mutex
+	clients   map[net.Conn][]string // subscribed topic filters by client
+	published chan *packets.PublishPacket
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := &testBroker{
+		listener:  listener,
+		clients:   map[net.Conn][]string{},
+		published: make(chan *packets.PublishPacket, 10),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(conn)
+		}
+	}()
+	return broker
+}
+
+func (broker *testBroker) URL() string {
+	return "tcp://" + broker.listener.Addr().String()
+}
+
+func (broker *testBroker) Close() {
+	broker.listener.Close()
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	for conn := range broker.clients {
+		conn.Close()
+	}
+}
+
+func (broker *testBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	broker.mutex.Lock()
+	broker.clients[conn] = nil
+	broker.mutex.Unlock()
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch data := packet.(type) {
+		case *packets.ConnectPacket:
+			broker.write(conn, packets.NewControlPacket(packets.Connack))
+		case *packets.SubscribePacket:
+			broker.mutex.Lock()
+			broker.clients[conn] = append(broker.clients[conn], data.Topics...)
+			broker.mutex.Unlock()
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = data.MessageID
+			suback.ReturnCodes = make([]byte, len(data.Topics))
+			broker.write(conn, suback)
+		case *packets.PublishPacket:
+			broker.published <- data
+			broker.mutex.Lock()
+			for subscriber, filters := range broker.clients {
+				for _, filter := range filters {
+					if mqttTopicMatches(filter, data.TopicName) {
+						data.Write(subscriber)
+						break
+					}
+				}
+			}
+			broker.mutex.Unlock()
+		case *packets.PingreqPacket:
+			broker.write(conn, packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func (broker *testBroker) write(conn net.Conn, packet packets.ControlPacket) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	packet.Write(conn)
+}
+
+// mqttTopicMatches matches a topic against a filter with + and # wildcards
+func mqttTopicMatches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// receive returns the next packet written to the connection by the dispatcher, skipping definitions
+func receive(t *testing.T, c *Connection) interface{} {
+	for {
+		select {
+		case packet := <-c.InChan:
+			switch packet.(type) {
+			case rotonde.Definition, rotonde.UnDefinition:
+				continue
+			}
+			return packet
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timeout waiting for a packet from the dispatcher")
+		}
+	}
+}
+
+func TestMQTTBridge(t *testing.T) {
+	broker := newTestBroker(t)
+	defer broker.Close()
+
+	d := NewDispatcher()
+	go d.Start()
+
+	config := &MQTTConfig{
+		Broker:   broker.URL(),
+		ClientID: "rotonde-test",
+		Events: []MQTTEventMapping{
+			{Topic: "sensors/+/temperature", Identifier: "TEMPERATURE", Fields: rotonde.FieldDefinitions{{Name: "value", Type: "number"}}},
+		},
+		Actions: []MQTTActionMapping{
+			{Identifier: "SET_LIGHT", Topic: "lights/set"},
+		},
+	}
+	go StartMQTT(d, config)
+
+	c := NewConnection()
+	d.AddConnection(c)
+	c.OutChan <- rotonde.Subscription{Identifier: "TEMPERATURE"}
+
+	// waits for the bridge definitions, the bridge is then connected and subscribed
+	deadline := time.After(5 * time.Second)
+	for found := 0; found < 2; {
+		select {
+		case packet := <-c.InChan:
+			if definition, ok := packet.(rotonde.Definition); ok && (definition.Identifier == "TEMPERATURE" || definition.Identifier == "SET_LIGHT") {
+				found++
+			}
+		case <-deadline:
+			t.Fatal("Timeout waiting for the MQTT bridge definitions")
+		}
+	}
+
+	// MQTT -> rotonde
+	publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	publish.TopicName = "sensors/kitchen/temperature"
+	publish.Payload = []byte("21.5")
+	publisher, err := net.Dial("tcp", broker.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer publisher.Close()
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ProtocolName = "MQTT"
+	connect.ProtocolVersion = 4
+	connect.ClientIdentifier = "publisher"
+	connect.Write(publisher)
+	packets.ReadPacket(publisher) // connack
+	publish.Write(publisher)
+	<-broker.published
+
+	event, ok := receive(t, c).(rotonde.Event)
+	expected := rotonde.Object{"value": 21.5, "topic": "sensors/kitchen/temperature"}
+	if ok == false || event.Identifier != "TEMPERATURE" || reflect.DeepEqual(event.Data, expected) == false {
+		t.Fatalf("Expected the TEMPERATURE event %v, got %+v", expected, event)
+	}
+
+	// rotonde -> MQTT
+	c.OutChan <- rotonde.Action{Identifier: "SET_LIGHT", Data: rotonde.Object{"on": true}}
+	select {
+	case published := <-broker.published:
+		if published.TopicName != "lights/set" || string(published.Payload) != `{"on":true}` {
+			t.Fatalf("Unexpected publish on %s: %s", published.TopicName, published.Payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the SET_LIGHT action to be published")
+	}
+}
+
+func TestMQTTBridgeUnreachableBroker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	d := NewDispatcher()
+	go d.Start()
+	go StartMQTT(d, &MQTTConfig{Broker: "tcp://" + address, ClientID: "rotonde-test"})
+	// lets the bridge fail its first connection attempt
+	time.Sleep(100 * time.Millisecond)
+
+	// more definitions than the InChan of a connection can hold
+	module := NewConnection()
+	d.AddConnection(module)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*ChanQueueLength; i++ {
+			definition := rotonde.Definition{Identifier: "EVENT_" + strings.Repeat("X", i), Type: "event"}
+			module.OutChan <- definition
+			module.OutChan <- rotonde.UnDefinition(definition)
+		}
+		c := NewConnection()
+		d.AddConnection(c)
+		<-c.Added()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The dispatcher is blocked while the MQTT broker is unreachable")
+	}
+}
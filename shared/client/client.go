@@ -0,0 +1,338 @@
+// Package client connects Go modules to rotonde, it keeps the connection
+// up, and takes care of the definitions and subscriptions bookkeeping.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/HackerLoop/rotonde/shared"
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/websocket"
+)
+
+// MaxBackoff is the maximum delay between two connection attempts
+const MaxBackoff = 30 * time.Second
+
+// ErrNotConnected is returned when sending a packet while the client is not connected
+var ErrNotConnected = errors.New("Not connected to rotonde")
+
+// EventHandler is called for each received event
+type EventHandler func(event rotonde.Event)
+
+// ActionHandler is called for each received action
+type ActionHandler func(action rotonde.Action)
+
+// Client is a connection to rotonde, it reconnects with backoff when the
+// connection is lost, and sends the local definitions and subscriptions
+// again after each reconnection.
+type Client struct {
+	url string
+
+	mutex sync.Mutex
+	conn  *websocket.Conn // nil when not connected
+
+	localDefinitions rotonde.Definitions // definitions this client exposes
+	definitions      rotonde.Definitions // definitions available on rotonde
+
+	eventHandlers  map[string][]EventHandler
+	actionHandlers map[string][]ActionHandler
+
+	waiters []*definitionWaiter
+
+	closed chan struct{}
+}
+
+type definitionWaiter struct {
+	identifier     string
+	definitionType string
+	found          chan *rotonde.Definition
+}
+
+// NewClient creates a client and starts connecting to the rotonde websocket at url (eg. ws://localhost:4224/)
+func NewClient(url string) *Client {
+	client := new(Client)
+	client.url = url
+	client.eventHandlers = make(map[string][]EventHandler)
+	client.actionHandlers = make(map[string][]ActionHandler)
+	client.closed = make(chan struct{})
+
+	go client.run()
+
+	return client
+}
+
+// Close disconnects the client, it won't reconnect
+func (client *Client) Close() {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	select {
+	case <-client.closed:
+		return
+	default:
+	}
+	close(client.closed)
+	if client.conn != nil {
+		client.conn.Close()
+	}
+}
+
+// AddLocalDefinition exposes an action or event to rotonde, when the client
+// is not connected the definition is sent once it connects
+func (client *Client) AddLocalDefinition(definition *rotonde.Definition) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	client.localDefinitions = rotonde.PushDefinition(client.localDefinitions, definition)
+	return client.sendState(*definition)
+}
+
+// RemoveLocalDefinition stops exposing an action or event to rotonde
+func (client *Client) RemoveLocalDefinition(identifier string) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	definition, err := client.localDefinitions.GetDefinitionForIdentifier(identifier)
+	if err != nil {
+		return err
+	}
+	client.localDefinitions = rotonde.RemoveDefinition(client.localDefinitions, identifier)
+	return client.sendState(rotonde.UnDefinition(*definition))
+}
+
+// OnEvent registers a handler for the events with the identifier, the client
+// subscribes to the identifier when its first handler is registered, or once
+// it connects.
+func (client *Client) OnEvent(identifier string, handler EventHandler) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	handlers, subscribed := client.eventHandlers[identifier]
+	client.eventHandlers[identifier] = append(handlers, handler)
+	if subscribed {
+		return nil
+	}
+	return client.sendState(rotonde.Subscription{Identifier: identifier})
+}
+
+// OnAction registers a handler for the actions with the identifier, the
+// matching action definition has to be added with AddLocalDefinition for
+// rotonde to route the actions to this client.
+func (client *Client) OnAction(identifier string, handler ActionHandler) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	client.actionHandlers[identifier] = append(client.actionHandlers[identifier], handler)
+}
+
+// SendEvent sends an event to rotonde
+func (client *Client) SendEvent(identifier string, data rotonde.Object) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.send(rotonde.Event{Identifier: identifier, Data: data})
+}
+
+// SendAction sends an action to rotonde
+func (client *Client) SendAction(identifier string, data rotonde.Object) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.send(rotonde.Action{Identifier: identifier, Data: data})
+}
+
+// SendBatch sends multiple events and actions in a single packet, rotonde
+// routes them as if they had been sent one by one. Requires a rotonde
+// instance supporting the protocol version 3.
+func (client *Client) SendBatch(packets ...interface{}) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return client.send(rotonde.Batch{Packets: packets})
+}
+
+// Definitions returns the definitions currently available on rotonde
+func (client *Client) Definitions() rotonde.Definitions {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	return append(rotonde.Definitions{}, client.definitions...)
+}
+
+// WaitForDefinition blocks until a definition with the identifier and type
+// ("action" or "event") is available on rotonde, or until timeout.
+func (client *Client) WaitForDefinition(identifier, definitionType string, timeout time.Duration) (*rotonde.Definition, error) {
+	client.mutex.Lock()
+	for _, definition := range client.definitions {
+		if definition.Identifier == identifier && definition.Type == definitionType {
+			client.mutex.Unlock()
+			return definition, nil
+		}
+	}
+	waiter := &definitionWaiter{identifier, definitionType, make(chan *rotonde.Definition, 1)}
+	client.waiters = append(client.waiters, waiter)
+	client.mutex.Unlock()
+
+	select {
+	case definition := <-waiter.found:
+		return definition, nil
+	case <-time.After(timeout):
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+		client.removeWaiter(waiter)
+		return nil, fmt.Errorf("Timeout waiting for %s %s", definitionType, identifier)
+	}
+}
+
+// send has to be called with the mutex locked
+func (client *Client) send(packet interface{}) error {
+	if client.conn == nil {
+		return ErrNotConnected
+	}
+	jsonPacket, err := rotonde.ToJSON(packet)
+	if err != nil {
+		return err
+	}
+	return client.conn.WriteMessage(websocket.TextMessage, jsonPacket)
+}
+
+// sendState sends a packet changing the definitions or subscriptions of the
+// client, they are sent again on connection, so there is nothing to report
+// when the client is not connected. It has to be called with the mutex locked.
+func (client *Client) sendState(packet interface{}) error {
+	if err := client.send(packet); err != ErrNotConnected {
+		return err
+	}
+	return nil
+}
+
+func (client *Client) removeWaiter(waiter *definitionWaiter) {
+	for i, w := range client.waiters {
+		if w == waiter {
+			client.waiters = append(client.waiters[:i], client.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// run connects to rotonde, and reconnects with backoff each time the connection is lost
+func (client *Client) run() {
+	backoff := time.Second
+	for {
+		conn, _, err := websocket.DefaultDialer.Dial(client.versionURL(), nil)
+		if err == nil {
+			backoff = time.Second
+			client.handleConnection(conn)
+		} else {
+			log.Warning(err)
+		}
+
+		select {
+		case <-client.closed:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > MaxBackoff {
+			backoff = MaxBackoff
+		}
+	}
+}
+
+// versionURL returns the url with the protocol version of the client, older
+// rotonde instances ignore it
+func (client *Client) versionURL() string {
+	versionURL, err := rotonde.WithVersion(client.url, rotonde.ProtocolVersion)
+	if err != nil {
+		return client.url
+	}
+	return versionURL
+}
+
+func (client *Client) handleConnection(conn *websocket.Conn) {
+	defer conn.Close()
+
+	client.mutex.Lock()
+	select {
+	case <-client.closed:
+		client.mutex.Unlock()
+		return
+	default:
+	}
+	client.conn = conn
+	// rotonde sends all its definitions again on connection
+	client.definitions = nil
+	for _, definition := range client.localDefinitions {
+		if err := client.send(*definition); err != nil {
+			log.Warning(err)
+		}
+	}
+	for identifier := range client.eventHandlers {
+		if err := client.send(rotonde.Subscription{Identifier: identifier}); err != nil {
+			log.Warning(err)
+		}
+	}
+	client.mutex.Unlock()
+	log.Info("Connected to rotonde ", client.url)
+
+	for {
+		messageType, reader, err := conn.NextReader()
+		if err != nil {
+			log.Warning(err)
+			break
+		}
+		if messageType != websocket.TextMessage {
+			continue
+		}
+		packet, err := rotonde.FromJSON(reader)
+		if err != nil {
+			log.Warning(err)
+			continue
+		}
+		client.handlePacket(packet)
+	}
+
+	client.mutex.Lock()
+	client.conn = nil
+	client.mutex.Unlock()
+}
+
+func (client *Client) handlePacket(packet interface{}) {
+	if batch, ok := packet.(rotonde.Batch); ok {
+		for _, batched := range batch.Packets {
+			client.handlePacket(batched)
+		}
+		return
+	}
+
+	client.mutex.Lock()
+	var eventHandlers []EventHandler
+	var actionHandlers []ActionHandler
+	switch data := packet.(type) {
+	case rotonde.Definition:
+		client.definitions = rotonde.PushDefinition(client.definitions, &data)
+		for _, waiter := range append([]*definitionWaiter{}, client.waiters...) {
+			if waiter.identifier == data.Identifier && waiter.definitionType == data.Type {
+				waiter.found <- &data
+				client.removeWaiter(waiter)
+			}
+		}
+	case rotonde.UnDefinition:
+		client.definitions = rotonde.RemoveDefinition(client.definitions, data.Identifier)
+	case rotonde.Event:
+		eventHandlers = append(eventHandlers, client.eventHandlers[data.Identifier]...)
+	case rotonde.Action:
+		actionHandlers = append(actionHandlers, client.actionHandlers[data.Identifier]...)
+	}
+	client.mutex.Unlock()
+
+	// handlers are called without the mutex, so they can use the client
+	for _, handler := range eventHandlers {
+		handler(packet.(rotonde.Event))
+	}
+	for _, handler := range actionHandlers {
+		handler(packet.(rotonde.Action))
+	}
+}
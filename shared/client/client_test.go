@@ -0,0 +1,77 @@
+package client
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HackerLoop/rotonde/shared"
+	"github.com/gorilla/websocket"
+)
+
+// recordingServer accepts websocket clients and sends the packets they write to packets
+func recordingServer(packets chan interface{}) http.HandlerFunc {
+	upgrader := websocket.Upgrader{}
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, reader, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+			if packet, err := rotonde.FromJSON(reader); err == nil {
+				packets <- packet
+			}
+		}
+	}
+}
+
+func TestClientQueuesUntilConnected(t *testing.T) {
+	// the server starts after the client, which is not connected yet
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient("ws://" + listener.Addr().String() + "/")
+	defer client.Close()
+
+	if err := client.AddLocalDefinition(&rotonde.Definition{Identifier: "BUTTON", Type: "event"}); err != nil {
+		t.Errorf("AddLocalDefinition: expected the definition to be queued, got %v", err)
+	}
+	if err := client.OnEvent("TEMPERATURE", func(rotonde.Event) {}); err != nil {
+		t.Errorf("OnEvent: expected the subscription to be queued, got %v", err)
+	}
+	if err := client.SendEvent("BUTTON", rotonde.Object{}); err != ErrNotConnected {
+		t.Errorf("SendEvent: expected ErrNotConnected, got %v", err)
+	}
+
+	packets := make(chan interface{}, 10)
+	server := &httptest.Server{Listener: listener, Config: &http.Server{Handler: recordingServer(packets)}}
+	server.Start()
+	defer server.Close()
+
+	var received []string
+	for len(received) < 2 {
+		select {
+		case packet := <-packets:
+			switch data := packet.(type) {
+			case rotonde.Definition:
+				received = append(received, "def "+data.Identifier)
+			case rotonde.Subscription:
+				received = append(received, "sub "+data.Identifier)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for the queued packets, got %v", received)
+		}
+	}
+	if strings.Join(received, ",") != "def BUTTON,sub TEMPERATURE" {
+		t.Errorf("Expected the definition and the subscription on connection, got %v", received)
+	}
+}
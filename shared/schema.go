@@ -0,0 +1,81 @@
+package rotonde
+
+// JSONSchemaVersion is the draft the generated schemas conform to
+const JSONSchemaVersion = "http://json-schema.org/draft-04/schema#"
+
+// JSONSchema converts a definition to a JSON Schema document describing the
+// data of its actions or events. Units are given as a "units" annotation on
+// the properties, and the kind of definition as a "rotondeType" annotation.
+func (definition *Definition) JSONSchema() Object {
+	schema := objectSchema(definition.Fields)
+	if definition.IsArray {
+		schema = Object{
+			"type":  "array",
+			"items": schema,
+		}
+	}
+	schema["$schema"] = JSONSchemaVersion
+	schema["title"] = definition.Identifier
+	schema["rotondeType"] = definition.Type
+	return schema
+}
+
+// JSONSchemas converts all the definitions
+func (definitions Definitions) JSONSchemas() []Object {
+	schemas := make([]Object, 0, len(definitions))
+	for _, definition := range definitions {
+		schemas = append(schemas, definition.JSONSchema())
+	}
+	return schemas
+}
+
+func objectSchema(fields FieldDefinitions) Object {
+	properties := Object{}
+	required := []string{}
+	for _, field := range fields {
+		properties[field.Name] = fieldSchema(field)
+		if field.Required {
+			required = append(required, field.Name)
+		}
+	}
+
+	schema := Object{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func fieldSchema(field *FieldDefinition) Object {
+	schema := Object{}
+	switch field.Type {
+	case "string", "number", "boolean":
+		schema["type"] = field.Type
+	case "object":
+		schema = objectSchema(field.Fields)
+	case "array":
+		schema["type"] = "array"
+		if field.Items != nil {
+			schema["items"] = fieldSchema(field.Items)
+		}
+	}
+	if field.Units != "" {
+		schema["units"] = field.Units
+	}
+	if len(field.Enum) > 0 {
+		schema["enum"] = field.Enum
+	}
+	if field.Min != nil {
+		schema["minimum"] = *field.Min
+	}
+	if field.Max != nil {
+		schema["maximum"] = *field.Max
+	}
+	if field.Default != nil {
+		schema["default"] = field.Default
+	}
+	return schema
+}
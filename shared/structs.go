@@ -1,13 +1,15 @@
 package rotonde
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/mitchellh/mapstructure"
+	"github.com/vmihailenco/msgpack"
 )
 
 // wrapper for json serialized connections
@@ -53,10 +55,20 @@ func RemoveDefinition(definitions Definitions, identifier string) Definitions {
 type FieldDefinitions []*FieldDefinition
 
 // FieldDefinition _
+// Only Name is mandatory, the other attributes are optional, and only
+// checked when present (see Definition.Validate).
 type FieldDefinition struct {
 	Name  string `json:"name"`
-	Type  string `json:"type"` // string, number or boolean
+	Type  string `json:"type"` // string, number, boolean, object or array
 	Units string `json:"units"`
+
+	Fields   FieldDefinitions `json:"fields,omitempty"`   // fields of object fields
+	Items    *FieldDefinition `json:"items,omitempty"`    // type of the items of array fields
+	Enum     []interface{}    `json:"enum,omitempty"`     // allowed values
+	Min      *float64         `json:"min,omitempty"`      // minimum of number fields
+	Max      *float64         `json:"max,omitempty"`      // maximum of number fields
+	Required bool             `json:"required,omitempty"` // fields are optional by default
+	Default  interface{}      `json:"default,omitempty"`  // value given to the field when missing
 }
 
 // Definition, used to expose an action or event
@@ -69,7 +81,7 @@ type Definition struct {
 }
 
 func (d *Definition) PushField(n, t, u string) {
-	field := FieldDefinition{n, t, u}
+	field := FieldDefinition{Name: n, Type: t, Units: u}
 	d.Fields = append(d.Fields, &field)
 }
 
@@ -98,65 +110,222 @@ type Unsubscription struct {
 	Identifier string `json:"identifier"`
 }
 
+// Auth authenticates the sending connection, it has to be the first packet
+// when the token is not given in the query string or headers
+type Auth struct {
+	Token string `json:"token"`
+}
+
+// Hello asks for a protocol version (see ProtocolVersion), rotonde answers
+// with a hello packet carrying the version it will use with the connection
+type Hello struct {
+	Version int `json:"version"`
+}
+
+// Batch carries multiple events and actions in a single packet, they are
+// routed as if they had been sent one by one
+type Batch struct {
+	Packets []interface{} `json:"packets"` // Event or Action
+}
+
+// batchPayload is the payload of batch packets, each packet is wrapped
+type batchPayload struct {
+	Packets []Packet `json:"packets"`
+}
+
+// ToJSON encodes a packet in JSON, the default encoding
 func ToJSON(object interface{}) ([]byte, error) {
-	var packet Packet
+	packet, err := ToPacket(object)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonPacket, err := json.Marshal(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	return jsonPacket, nil
+}
+
+// ToMsgpack encodes a packet in MessagePack, using the same field names as the JSON encoding
+func ToMsgpack(object interface{}) ([]byte, error) {
+	packet, err := ToPacket(object)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	if err := msgpack.NewEncoder(&buffer).UseJSONTag(true).Encode(packet); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// ToPacket wraps an object in a Packet, with the type matching the object
+func ToPacket(object interface{}) (Packet, error) {
 	switch data := object.(type) {
 	case Event:
-		packet = Packet{Type: "event", Payload: data}
+		return Packet{Type: "event", Payload: data}, nil
 	case Action:
-		packet = Packet{Type: "action", Payload: data}
+		return Packet{Type: "action", Payload: data}, nil
 	case Subscription:
-		packet = Packet{Type: "sub", Payload: data}
+		return Packet{Type: "sub", Payload: data}, nil
 	case Unsubscription:
-		packet = Packet{Type: "unsub", Payload: data}
+		return Packet{Type: "unsub", Payload: data}, nil
 	case Definition:
-		packet = Packet{Type: "def", Payload: data}
+		return Packet{Type: "def", Payload: data}, nil
 	case UnDefinition:
-		packet = Packet{Type: "undef", Payload: data}
-	default:
-		log.Fatal("Oops unknown packet: ", object)
+		return Packet{Type: "undef", Payload: data}, nil
+	case Auth:
+		return Packet{Type: "auth", Payload: data}, nil
+	case Hello:
+		return Packet{Type: "hello", Payload: data}, nil
+	case Batch:
+		payload := batchPayload{Packets: make([]Packet, 0, len(data.Packets))}
+		for _, object := range data.Packets {
+			if IsBatchable(object) == false {
+				return Packet{}, fmt.Errorf("%T can't be batched", object)
+			}
+			packet, err := ToPacket(object)
+			if err != nil {
+				return Packet{}, err
+			}
+			payload.Packets = append(payload.Packets, packet)
+		}
+		return Packet{Type: "batch", Payload: payload}, nil
 	}
+	return Packet{}, fmt.Errorf("Unknown packet %T", object)
+}
 
-	jsonPacket, err := json.Marshal(packet)
-	if err != nil {
+// FromJSON decodes a JSON encoded packet, unknown keys are rejected
+func FromJSON(reader io.Reader) (interface{}, error) {
+	packet := Packet{}
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&packet); err != nil {
 		return nil, err
 	}
 
-	return jsonPacket, nil
+	return FromPacket(packet)
 }
 
-func FromJSON(reader io.Reader) (interface{}, error) {
+// FromMsgpack decodes a MessagePack encoded packet
+func FromMsgpack(reader io.Reader) (interface{}, error) {
 	packet := Packet{}
-	decoder := json.NewDecoder(reader)
+	decoder := msgpack.NewDecoder(reader).UseJSONTag(true)
 	if err := decoder.Decode(&packet); err != nil {
 		return nil, err
 	}
 
+	return FromPacket(packet)
+}
+
+// FromPacket converts the payload of a Packet to the object matching its type,
+// payloads with unknown keys, values of the wrong type or without identifier are rejected
+func FromPacket(packet Packet) (interface{}, error) {
+	var object interface{}
+	var identifier string
+	var err error
 	switch packet.Type {
 	case "event":
 		event := Event{}
-		mapstructure.Decode(packet.Payload, &event)
-		return event, nil
+		err = decodePayload(packet, &event)
+		object, identifier = event, event.Identifier
 	case "action":
 		action := Action{}
-		mapstructure.Decode(packet.Payload, &action)
-		return action, nil
+		err = decodePayload(packet, &action)
+		object, identifier = action, action.Identifier
 	case "sub":
 		subscription := Subscription{}
-		mapstructure.Decode(packet.Payload, &subscription)
-		return subscription, nil
+		err = decodePayload(packet, &subscription)
+		object, identifier = subscription, subscription.Identifier
 	case "unsub":
 		unsubscription := Unsubscription{}
-		mapstructure.Decode(packet.Payload, &unsubscription)
-		return unsubscription, nil
+		err = decodePayload(packet, &unsubscription)
+		object, identifier = unsubscription, unsubscription.Identifier
 	case "def":
 		definition := Definition{}
-		mapstructure.Decode(packet.Payload, &definition)
-		return definition, nil
+		err = decodePayload(packet, &definition)
+		object, identifier = definition, definition.Identifier
+		if err == nil && isDefinitionType(definition.Type) == false {
+			err = fmt.Errorf("Invalid def packet: type \"%s\" is not action or event", definition.Type)
+		}
 	case "undef":
 		unDefinition := UnDefinition{}
-		mapstructure.Decode(packet.Payload, &unDefinition)
-		return unDefinition, nil
+		err = decodePayload(packet, &unDefinition)
+		object, identifier = unDefinition, unDefinition.Identifier
+		if err == nil && isDefinitionType(unDefinition.Type) == false {
+			err = fmt.Errorf("Invalid undef packet: type \"%s\" is not action or event", unDefinition.Type)
+		}
+	case "auth":
+		auth := Auth{}
+		err = decodePayload(packet, &auth)
+		object, identifier = auth, ""
+	case "hello":
+		hello := Hello{}
+		err = decodePayload(packet, &hello)
+		object, identifier = hello, ""
+	case "batch":
+		payload := batchPayload{}
+		if err := decodePayload(packet, &payload); err != nil {
+			return nil, err
+		}
+		batch := Batch{Packets: make([]interface{}, 0, len(payload.Packets))}
+		for _, batchedPacket := range payload.Packets {
+			object, err := FromPacket(batchedPacket)
+			if err != nil {
+				return nil, err
+			}
+			if IsBatchable(object) == false {
+				return nil, fmt.Errorf("Invalid batch packet: %s packets can't be batched", batchedPacket.Type)
+			}
+			batch.Packets = append(batch.Packets, object)
+		}
+		return batch, nil
+	default:
+		return nil, fmt.Errorf("Unknown packet type \"%s\"", packet.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if identifier == "" && packet.Type != "auth" && packet.Type != "hello" {
+		return nil, fmt.Errorf("Invalid %s packet: missing identifier", packet.Type)
+	}
+	return object, nil
+}
+
+func isDefinitionType(definitionType string) bool {
+	return definitionType == "action" || definitionType == "event"
+}
+
+// IsBatchable returns true for the packets that can be carried by a batch
+func IsBatchable(object interface{}) bool {
+	switch object.(type) {
+	case Event, Action:
+		return true
+	}
+	return false
+}
+
+// decodePayload decodes the payload of the packet in result, using the json
+// tags as keys, unknown keys and values of the wrong type are errors
+func decodePayload(packet Packet, result interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		ErrorUnused: true,
+		TagName:     "json",
+		Result:      result,
+	})
+	if err != nil {
+		return err
+	}
+	if err := decoder.Decode(packet.Payload); err != nil {
+		// mapstructure reports all the errors on multiple lines, keep them on one
+		if decodeErr, ok := err.(*mapstructure.Error); ok {
+			return fmt.Errorf("Invalid %s packet: %s", packet.Type, strings.Join(decodeErr.Errors, ", "))
+		}
+		return fmt.Errorf("Invalid %s packet: %s", packet.Type, err)
 	}
-	return nil, fmt.Errorf("%s not found", packet.Type)
+	return nil
 }
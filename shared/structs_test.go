@@ -0,0 +1,88 @@
+package rotonde
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFromJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		expected interface{} // nil when decoding fails
+		err      string
+	}{
+		{"event", `{"type": "event", "payload": {"identifier": "BUTTON", "data": {"pressed": true}}}`, Event{Identifier: "BUTTON", Data: Object{"pressed": true}}, ""},
+		{"sub", `{"type": "sub", "payload": {"identifier": "BUTTON"}}`, Subscription{Identifier: "BUTTON"}, ""},
+		{"hello without identifier", `{"type": "hello", "payload": {"version": 2}}`, Hello{Version: 2}, ""},
+		{"unknown payload key", `{"type": "event", "payload": {"identifier": "BUTTON", "datas": {}}}`, nil, "Invalid event packet"},
+		{"unknown packet key", `{"type": "event", "payload": {"identifier": "BUTTON"}, "extra": 1}`, nil, "unknown field"},
+		{"wrong type", `{"type": "action", "payload": {"identifier": 1}}`, nil, "Invalid action packet"},
+		{"wrong data type", `{"type": "event", "payload": {"identifier": "BUTTON", "data": "pressed"}}`, nil, "Invalid event packet"},
+		{"missing identifier", `{"type": "def", "payload": {"type": "action"}}`, nil, "Invalid def packet: missing identifier"},
+		{"def of unknown type", `{"type": "def", "payload": {"identifier": "LED", "type": "sensor"}}`, nil, "Invalid def packet: type \"sensor\" is not action or event"},
+		{"undef without type", `{"type": "undef", "payload": {"identifier": "LED"}}`, nil, "Invalid undef packet: type \"\" is not action or event"},
+		{"unknown packet type", `{"type": "ping", "payload": {}}`, nil, "Unknown packet type \"ping\""},
+		{"batched sub", `{"type": "batch", "payload": {"packets": [{"type": "sub", "payload": {"identifier": "BUTTON"}}]}}`, nil, "sub packets can't be batched"},
+		{"invalid batched packet", `{"type": "batch", "payload": {"packets": [{"type": "event", "payload": {}}]}}`, nil, "missing identifier"},
+	}
+
+	for _, test := range tests {
+		object, err := FromJSON(strings.NewReader(test.json))
+		if test.err != "" {
+			if err == nil || strings.Contains(err.Error(), test.err) == false {
+				t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if reflect.DeepEqual(object, test.expected) == false {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.expected, object)
+		}
+	}
+}
+
+func TestToPacketUnknownType(t *testing.T) {
+	if _, err := ToJSON(struct{}{}); err == nil || strings.Contains(err.Error(), "Unknown packet") == false {
+		t.Errorf("Expected an unknown packet error, got %v", err)
+	}
+	if _, err := ToJSON(Batch{Packets: []interface{}{Subscription{Identifier: "BUTTON"}}}); err == nil {
+		t.Error("Expected subscriptions to be rejected from batches")
+	}
+}
+
+func TestBatchRoundTrip(t *testing.T) {
+	batch := Batch{Packets: []interface{}{
+		Event{Identifier: "TEMPERATURE", Data: Object{"value": 21.5}},
+		Action{Identifier: "LED", Data: Object{"mode": "blink"}},
+	}}
+
+	jsonPacket, err := ToJSON(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := FromJSON(bytes.NewReader(jsonPacket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(fromJSON, batch) == false {
+		t.Errorf("JSON: expected %#v, got %#v", batch, fromJSON)
+	}
+
+	msgpackPacket, err := ToMsgpack(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromMsgpack, err := FromMsgpack(bytes.NewReader(msgpackPacket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(fromMsgpack, batch) == false {
+		t.Errorf("msgpack: expected %#v, got %#v", batch, fromMsgpack)
+	}
+}
@@ -0,0 +1,176 @@
+package rotonde
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Validate checks the data of an action or event against the fields of the
+// definition, and returns the data with the default values of the missing
+// fields. Fields of data that are not in the definition are accepted, as
+// are fields without type, so definitions that only give names (as before
+// types were checked) don't reject anything.
+func (definition *Definition) Validate(data Object) (Object, error) {
+	if definition.IsArray {
+		return data, nil
+	}
+	validated, err := validateObject(definition.Fields, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", definition.Identifier, err)
+	}
+	return validated, nil
+}
+
+// validateObject works on a copy, the original data might be shared with the sender
+func validateObject(fields FieldDefinitions, data Object) (Object, error) {
+	validated := copyObject(data)
+	for _, field := range fields {
+		value, ok := data[field.Name]
+		if ok == false || value == nil {
+			if field.Default != nil {
+				validated[field.Name] = field.Default
+			} else if field.Required {
+				return nil, fmt.Errorf("missing field %s", field.Name)
+			}
+			continue
+		}
+
+		value, err := validateValue(field, value)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %s", field.Name, err)
+		}
+		validated[field.Name] = value
+	}
+	return validated, nil
+}
+
+func validateValue(field *FieldDefinition, value interface{}) (interface{}, error) {
+	switch field.Type {
+	case "string":
+		if _, ok := value.(string); ok == false {
+			return nil, fmt.Errorf("expected a string, got %T", value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); ok == false {
+			return nil, fmt.Errorf("expected a boolean, got %T", value)
+		}
+	case "number":
+		number, ok := ToFloat(value)
+		if ok == false {
+			return nil, fmt.Errorf("expected a number, got %T", value)
+		}
+		if field.Min != nil && number < *field.Min {
+			return nil, fmt.Errorf("%v is lower than %v", number, *field.Min)
+		}
+		if field.Max != nil && number > *field.Max {
+			return nil, fmt.Errorf("%v is greater than %v", number, *field.Max)
+		}
+	case "object":
+		object, ok := toObject(value)
+		if ok == false {
+			return nil, fmt.Errorf("expected an object, got %T", value)
+		}
+		validated, err := validateObject(field.Fields, object)
+		if err != nil {
+			return nil, err
+		}
+		value = validated
+	case "array":
+		// any slice, in-process modules can send []float64 or []string
+		items := reflect.ValueOf(value)
+		if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+			return nil, fmt.Errorf("expected an array, got %T", value)
+		}
+		if field.Items != nil {
+			for i := 0; i < items.Len(); i++ {
+				if _, err := validateValue(field.Items, items.Index(i).Interface()); err != nil {
+					return nil, fmt.Errorf("item %d: %s", i, err)
+				}
+			}
+		}
+	}
+
+	if len(field.Enum) > 0 && inEnum(field.Enum, value) == false {
+		return nil, fmt.Errorf("%v is not one of %v", value, field.Enum)
+	}
+	return value, nil
+}
+
+// toObject converts maps with string keys to an Object, in-process modules
+// can send map[string]float64 or map[string]string
+func toObject(value interface{}) (Object, bool) {
+	switch object := value.(type) {
+	case Object:
+		return object, true
+	case map[string]interface{}:
+		return object, true
+	}
+	items := reflect.ValueOf(value)
+	if items.Kind() != reflect.Map || items.Type().Key().Kind() != reflect.String {
+		return nil, false
+	}
+	object := make(Object, items.Len())
+	for _, key := range items.MapKeys() {
+		object[key.String()] = items.MapIndex(key).Interface()
+	}
+	return object, true
+}
+
+// inEnum compares numbers by value whatever their type, and the other values
+// with reflect.DeepEqual, enums can hold arrays and objects which can't be
+// compared with ==
+func inEnum(enum []interface{}, value interface{}) bool {
+	number, isNumber := ToFloat(value)
+	for _, allowed := range enum {
+		if allowedNumber, ok := ToFloat(allowed); ok && isNumber {
+			if allowedNumber == number {
+				return true
+			}
+		} else if allowedObject, ok := toObject(allowed); ok {
+			if object, ok := toObject(value); ok && reflect.DeepEqual(allowedObject, object) {
+				return true
+			}
+		} else if reflect.DeepEqual(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func copyObject(data Object) Object {
+	object := make(Object, len(data))
+	for key, value := range data {
+		object[key] = value
+	}
+	return object
+}
+
+// ToFloat converts the numbers decoded from JSON (float64), MessagePack or
+// sent by in-process modules (integers) to float64
+func ToFloat(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case float64:
+		return number, true
+	case float32:
+		return float64(number), true
+	case int:
+		return float64(number), true
+	case int8:
+		return float64(number), true
+	case int16:
+		return float64(number), true
+	case int32:
+		return float64(number), true
+	case int64:
+		return float64(number), true
+	case uint8:
+		return float64(number), true
+	case uint16:
+		return float64(number), true
+	case uint32:
+		return float64(number), true
+	case uint64:
+		return float64(number), true
+	}
+	return 0, false
+}
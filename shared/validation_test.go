@@ -0,0 +1,86 @@
+package rotonde
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func validationDefinition() *Definition {
+	min, max := 0.0, 10.0
+	return &Definition{
+		Identifier: "LED",
+		Type:       "action",
+		Fields: FieldDefinitions{
+			{Name: "level", Type: "number", Min: &min, Max: &max, Required: true},
+			{Name: "mode", Type: "string", Enum: []interface{}{"solid", "blink"}, Default: "solid"},
+			{Name: "color", Type: "object", Fields: FieldDefinitions{{Name: "red", Type: "number", Required: true}}},
+			{Name: "samples", Type: "array", Items: &FieldDefinition{Type: "number"}},
+			{Name: "on", Type: "boolean"},
+			{Name: "legacy"},
+			{Name: "point", Type: "array", Enum: []interface{}{[]interface{}{1.0, 2.0}}},
+			{Name: "corner", Type: "object", Enum: []interface{}{map[string]interface{}{"x": 0.0}}},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     Object
+		expected Object // nil when validation fails
+		err      string
+	}{
+		{"defaults", Object{"level": 1.0}, Object{"level": 1.0, "mode": "solid"}, ""},
+		{"integer", Object{"level": 3}, Object{"level": 3, "mode": "solid"}, ""},
+		{"missing required", Object{}, nil, "missing field level"},
+		{"below min", Object{"level": -1.0}, nil, "lower than"},
+		{"above max", Object{"level": 11.0}, nil, "greater than"},
+		{"wrong type", Object{"level": "high"}, nil, "expected a number"},
+		{"not in enum", Object{"level": 1.0, "mode": "off"}, nil, "is not one of"},
+		{"nested", Object{"level": 1.0, "color": map[string]interface{}{"red": 1.0}}, Object{"level": 1.0, "mode": "solid", "color": Object{"red": 1.0}}, ""},
+		{"nested missing", Object{"level": 1.0, "color": map[string]interface{}{}}, nil, "field color: missing field red"},
+		{"json array", Object{"level": 1.0, "samples": []interface{}{1.0, 2.0}}, Object{"level": 1.0, "mode": "solid", "samples": []interface{}{1.0, 2.0}}, ""},
+		{"typed array", Object{"level": 1.0, "samples": []float64{1, 2}}, Object{"level": 1.0, "mode": "solid", "samples": []float64{1, 2}}, ""},
+		{"wrong items", Object{"level": 1.0, "samples": []string{"a"}}, nil, "item 0"},
+		{"not an array", Object{"level": 1.0, "samples": 1.0}, nil, "expected an array"},
+		{"typed object", Object{"level": 1.0, "color": map[string]float64{"red": 1}}, Object{"level": 1.0, "mode": "solid", "color": Object{"red": 1.0}}, ""},
+		{"typed object missing", Object{"level": 1.0, "color": map[string]float64{}}, nil, "field color: missing field red"},
+		{"integer keys", Object{"level": 1.0, "color": map[int]float64{1: 1}}, nil, "expected an object"},
+		{"array enum", Object{"level": 1.0, "point": []interface{}{1.0, 2.0}}, Object{"level": 1.0, "mode": "solid", "point": []interface{}{1.0, 2.0}}, ""},
+		{"not in array enum", Object{"level": 1.0, "point": []interface{}{2.0, 1.0}}, nil, "is not one of"},
+		{"object enum", Object{"level": 1.0, "corner": map[string]interface{}{"x": 0.0}}, Object{"level": 1.0, "mode": "solid", "corner": Object{"x": 0.0}}, ""},
+		{"not in object enum", Object{"level": 1.0, "corner": map[string]interface{}{"x": 1.0}}, nil, "is not one of"},
+		{"boolean", Object{"level": 1.0, "on": "yes"}, nil, "expected a boolean"},
+		{"untyped and unknown", Object{"level": 1.0, "legacy": 1, "extra": true}, Object{"level": 1.0, "mode": "solid", "legacy": 1, "extra": true}, ""},
+	}
+
+	for _, test := range tests {
+		data := copyObject(test.data)
+		validated, err := validationDefinition().Validate(data)
+		if test.err != "" {
+			if err == nil || strings.Contains(err.Error(), test.err) == false {
+				t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if reflect.DeepEqual(validated, test.expected) == false {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, validated)
+		}
+		if reflect.DeepEqual(data, test.data) == false {
+			t.Errorf("%s: the data was modified", test.name)
+		}
+	}
+}
+
+func TestValidateArrayDefinition(t *testing.T) {
+	definition := validationDefinition()
+	definition.IsArray = true
+	if _, err := definition.Validate(Object{"anything": 1}); err != nil {
+		t.Errorf("array definitions are not validated, got %v", err)
+	}
+}
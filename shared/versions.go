@@ -0,0 +1,94 @@
+package rotonde
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Protocol versions:
+//
+//  1. the original protocol, fields are described by their name, type
+//     (string, number or boolean) and units.
+//  2. adds the hello packet, and the object and array field types with the
+//     fields, items, enum, min, max, required and default attributes.
+//  3. adds the batch packet.
+//
+// Peers that don't negotiate a version speak the version 1.
+const ProtocolVersion = 3
+const MinProtocolVersion = 1
+
+// ExtendedFieldsVersion is the first version in which definitions carry the
+// object and array types and the extended field attributes
+const ExtendedFieldsVersion = 2
+
+// BatchVersion is the first version in which peers can receive batch packets
+const BatchVersion = 3
+
+// VersionParameter is the query parameter used to ask for a version when connecting
+const VersionParameter = "version"
+
+// NegotiateVersion returns the version to use with a peer asking for version,
+// the latest version is used when the peer knows a more recent one
+func NegotiateVersion(version int) (int, error) {
+	if version < MinProtocolVersion {
+		return 0, fmt.Errorf("Unsupported protocol version %d", version)
+	}
+	if version > ProtocolVersion {
+		return ProtocolVersion, nil
+	}
+	return version, nil
+}
+
+// WithVersion adds the version query parameter to a websocket URL
+func WithVersion(rawURL string, version int) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set(VersionParameter, strconv.Itoa(version))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// ForVersion translates a packet, in the latest version, to the packets
+// understood by peers speaking the given version: batches are unpacked below
+// BatchVersion, the extended field attributes are removed below
+// ExtendedFieldsVersion, packets that didn't change are returned as is
+func ForVersion(packet interface{}, version int) []interface{} {
+	switch data := packet.(type) {
+	case Batch:
+		if version < BatchVersion {
+			return data.Packets
+		}
+	case Definition:
+		if version < ExtendedFieldsVersion {
+			data.Fields = v1Fields(data.Fields)
+		}
+		return []interface{}{data}
+	case UnDefinition:
+		if version < ExtendedFieldsVersion {
+			data.Fields = v1Fields(data.Fields)
+		}
+		return []interface{}{data}
+	}
+	return []interface{}{packet}
+}
+
+// v1Fields copies fields without the attributes of the version 2, object
+// and array fields become fields without type
+func v1Fields(fields FieldDefinitions) FieldDefinitions {
+	if fields == nil {
+		return nil
+	}
+	v1 := make(FieldDefinitions, 0, len(fields))
+	for _, field := range fields {
+		fieldType := field.Type
+		if fieldType == "object" || fieldType == "array" {
+			fieldType = ""
+		}
+		v1 = append(v1, &FieldDefinition{Name: field.Name, Type: fieldType, Units: field.Units})
+	}
+	return v1
+}
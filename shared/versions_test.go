@@ -0,0 +1,114 @@
+package rotonde
+
+import (
+	"reflect"
+	"testing"
+)
+
+func extendedDefinition() Definition {
+	min := 0.0
+	return Definition{
+		Identifier: "LED",
+		Type:       "action",
+		Fields: FieldDefinitions{
+			{Name: "level", Type: "number", Units: "percent", Min: &min, Required: true},
+			{Name: "color", Type: "object", Fields: FieldDefinitions{{Name: "red", Type: "number"}}},
+			{Name: "mode", Type: "string", Enum: []interface{}{"solid", "blink"}, Default: "solid"},
+		},
+	}
+}
+
+func TestForVersionDefinition(t *testing.T) {
+	v1 := FieldDefinitions{
+		{Name: "level", Type: "number", Units: "percent"},
+		{Name: "color"},
+		{Name: "mode", Type: "string"},
+	}
+
+	tests := []struct {
+		version int
+		fields  FieldDefinitions
+	}{
+		{1, v1},
+		{2, extendedDefinition().Fields},
+		{3, extendedDefinition().Fields},
+	}
+	for _, test := range tests {
+		definition := extendedDefinition()
+		packets := ForVersion(definition, test.version)
+		if len(packets) != 1 {
+			t.Fatalf("version %d: expected 1 packet, got %d", test.version, len(packets))
+		}
+		translated, ok := packets[0].(Definition)
+		if ok == false {
+			t.Fatalf("version %d: expected a Definition, got %T", test.version, packets[0])
+		}
+		if reflect.DeepEqual(translated.Fields, test.fields) == false {
+			t.Errorf("version %d: unexpected fields %+v", test.version, translated.Fields)
+		}
+		if reflect.DeepEqual(definition, extendedDefinition()) == false {
+			t.Errorf("version %d: the original definition was modified", test.version)
+		}
+	}
+
+	unDefinition := UnDefinition(extendedDefinition())
+	translated := ForVersion(unDefinition, 1)[0].(UnDefinition)
+	if reflect.DeepEqual(translated.Fields, v1) == false {
+		t.Errorf("version 1: unexpected undef fields %+v", translated.Fields)
+	}
+}
+
+func TestForVersionBatch(t *testing.T) {
+	batch := Batch{Packets: []interface{}{
+		Event{Identifier: "ACCELEROMETER", Data: Object{"x": 1.0}},
+		Action{Identifier: "LED", Data: Object{"level": 10.0}},
+	}}
+
+	tests := []struct {
+		version int
+		packets []interface{}
+	}{
+		{1, batch.Packets},
+		{2, batch.Packets},
+		{3, []interface{}{batch}},
+	}
+	for _, test := range tests {
+		packets := ForVersion(batch, test.version)
+		if reflect.DeepEqual(packets, test.packets) == false {
+			t.Errorf("version %d: expected %+v, got %+v", test.version, test.packets, packets)
+		}
+	}
+}
+
+func TestForVersionUnchanged(t *testing.T) {
+	event := Event{Identifier: "BUTTON", Data: Object{"pressed": true}}
+	for version := MinProtocolVersion; version <= ProtocolVersion; version++ {
+		packets := ForVersion(event, version)
+		if reflect.DeepEqual(packets, []interface{}{event}) == false {
+			t.Errorf("version %d: expected the event unchanged, got %+v", version, packets)
+		}
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		asked      int
+		negotiated int
+		fails      bool
+	}{
+		{0, 0, true},
+		{1, 1, false},
+		{2, 2, false},
+		{ProtocolVersion, ProtocolVersion, false},
+		{ProtocolVersion + 1, ProtocolVersion, false},
+	}
+	for _, test := range tests {
+		negotiated, err := NegotiateVersion(test.asked)
+		if (err != nil) != test.fails {
+			t.Errorf("version %d: unexpected error %v", test.asked, err)
+		}
+		if negotiated != test.negotiated {
+			t.Errorf("version %d: expected %d, got %d", test.asked, test.negotiated, negotiated)
+		}
+	}
+}
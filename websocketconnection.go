@@ -1,44 +1,274 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/HackerLoop/rotonde/shared"
 	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/websocket"
 )
 
+// WebsocketOptions configures the websocket server
+type WebsocketOptions struct {
+	Port int
+
+	// serve wss:// when TLSCert and TLSKey are set, client certificates are
+	// required and verified against TLSClientCA when it is set.
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
+
+	// when set, clients of the endpoints that require it have to
+	// authenticate with one of its tokens before being added to the dispatcher
+	Authenticator *Authenticator
+
+	// websocket mount points, mapped by path
+	Endpoints map[string]*Endpoint
+
+	// browser origins allowed to connect, any origin is allowed when empty,
+	// requests without Origin header (non-browser clients) are always allowed
+	AllowedOrigins []string
+
+	ReadBufferSize  int
+	WriteBufferSize int
+	MaxMessageSize  int64         // bigger messages close the connection
+	WriteTimeout    time.Duration // time allowed to write a message to the peer, 0 disables it
+	IdleTimeout     time.Duration // peers that send nothing for this long are closed, 0 disables it
+
+	// a ping is sent every PingInterval, peers that don't answer within
+	// PongTimeout are considered dead and closed, 0 disables pings.
+	// PongTimeout has to be greater than PingInterval.
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+
+	// negotiate permessage-deflate with the clients that support it, only
+	// messages of at least CompressionThreshold bytes are compressed
+	EnableCompression    bool
+	CompressionThreshold int
+
+	// events and actions queued for a peer are coalesced in batch packets of
+	// at most BatchSize packets, for the peers speaking a version that
+	// supports batches, 0 or 1 disables it
+	BatchSize int
+
+	// name of this instance and the instances it federates with, a link
+	// opened by one of them is refused when it would duplicate ours
+	Instance        string
+	FederationLinks []FederationLink
+}
+
+// websocket subprotocols, JSON in text frames is used when the client doesn't ask for one
+const JSONSubprotocol = "rotonde.json"
+const MsgpackSubprotocol = "rotonde.msgpack"
+
+// AuthTimeout is how long a client has to send its auth packet, a variable
+// so tests don't wait for it
+var AuthTimeout = 10 * time.Second
+
 // Start the websocket server, each peer connecting to this websocket will be added as a connection to the dispatcher
-func StartWebsocket(d *Dispatcher, port int) {
+func StartWebsocket(d *Dispatcher, options WebsocketOptions) {
+	for mountPoint, endpoint := range options.Endpoints {
+		http.HandleFunc(mountPoint, websocketHandler(d, options, endpoint))
+		log.Infof("Websocket endpoint %s", mountPoint)
+	}
+
+	registerHTTPGateway(d, options)
+
+	server := &http.Server{Addr: fmt.Sprintf(":%d", options.Port)}
+	if options.TLSCert == "" && options.TLSKey == "" && options.TLSClientCA == "" {
+		go func() {
+			log.Fatal(server.ListenAndServe())
+		}()
+		log.Println(fmt.Sprintf("Websocket server started on port %d", options.Port))
+		select {}
+	}
+
+	tlsConfig, err := newTLSConfig(options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.TLSConfig = tlsConfig
+	go func() {
+		log.Fatal(server.ListenAndServeTLS(options.TLSCert, options.TLSKey))
+	}()
+	log.Println(fmt.Sprintf("Websocket server started with TLS on port %d", options.Port))
+	select {}
+}
+
+// websocketHandler upgrades the requests to the endpoint, and adds them as connections to the dispatcher
+func websocketHandler(d *Dispatcher, options WebsocketOptions, endpoint *Endpoint) http.HandlerFunc {
+	subprotocols := []string{JSONSubprotocol, MsgpackSubprotocol}
+	if endpoint.Encoding == "json" {
+		subprotocols = []string{JSONSubprotocol}
+	} else if endpoint.Encoding == "msgpack" {
+		subprotocols = []string{MsgpackSubprotocol}
+	}
+
 	var upgrader = websocket.Upgrader{
-		ReadBufferSize:  2048,
-		WriteBufferSize: 2048,
+		ReadBufferSize:    options.ReadBufferSize,
+		WriteBufferSize:   options.WriteBufferSize,
+		Subprotocols:      subprotocols,
+		EnableCompression: options.EnableCompression,
 		CheckOrigin: func(r *http.Request) bool {
-			return true
+			return checkOrigin(r, options.AllowedOrigins)
 		},
 	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
 		log.Debug("Connection received")
+		version, err := requestedVersion(r)
+		if err != nil {
+			log.Warning(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// federation links opened by remote instances
+		federation := r.URL.Query().Get(FederationParameter)
+		if isReverseLink(federation, options.Instance, options.FederationLinks) {
+			log.Warningf("Refusing the federation link of %s, this instance federates with it", federation)
+			http.Error(w, "federation link already established", http.StatusConflict)
+			return
+		}
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			log.Fatal(err)
+			log.Warning(err)
+			return
 		}
+		conn.SetReadLimit(options.MaxMessageSize)
 
 		defer conn.Close()
 
-		startWebsocketConnection(conn, d)
-	})
+		identity := ""
+		if endpoint.Auth {
+			identity, err = authenticateWebsocket(conn, r, options.Authenticator, endpoint)
+			if err != nil {
+				log.Warning("Websocket authentication failed: ", err)
+				closeMessage := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error())
+				conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+				return
+			}
+			log.Info("Websocket authenticated as ", identity)
+		}
 
-	go http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
-	log.Println(fmt.Sprintf("Websocket server started on port %d", port))
-	select {}
+		startWebsocketConnection(conn, d, identity, version, federation != "", options, endpoint)
+	}
+}
+
+// requestedVersion returns the protocol version negotiated with the version
+// query parameter, or 0 when it is not given
+func requestedVersion(r *http.Request) (int, error) {
+	parameter := r.URL.Query().Get(rotonde.VersionParameter)
+	if parameter == "" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(parameter)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid protocol version %s", parameter)
+	}
+	return rotonde.NegotiateVersion(version)
+}
+
+// checkOrigin returns true if the origin of the request is in allowedOrigins
+func checkOrigin(r *http.Request, allowedOrigins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(allowedOrigins) == 0 {
+		return true
+	}
+	for _, allowedOrigin := range allowedOrigins {
+		if allowedOrigin == "*" || allowedOrigin == origin {
+			return true
+		}
+	}
+	log.Warning("Rejected websocket connection from origin ", origin)
+	return false
 }
 
-func startWebsocketConnection(conn *websocket.Conn, d *Dispatcher) {
+// newTLSConfig checks the TLS options, and loads the client CA if client certificates have to be verified
+func newTLSConfig(options WebsocketOptions) (*tls.Config, error) {
+	if options.TLSCert == "" || options.TLSKey == "" {
+		return nil, fmt.Errorf("Both -tls-cert and -tls-key are required to enable TLS")
+	}
+	tlsConfig := &tls.Config{}
+	if options.TLSClientCA == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := ioutil.ReadFile(options.TLSClientCA)
+	if err != nil {
+		return nil, err
+	}
+	clientCAs := x509.NewCertPool()
+	if clientCAs.AppendCertsFromPEM(pem) == false {
+		return nil, fmt.Errorf("No certificate found in %s", options.TLSClientCA)
+	}
+	tlsConfig.ClientCAs = clientCAs
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig, nil
+}
+
+// websocketCodec returns the encoding functions and the frame type of the
+// endpoint encoding, or of the subprotocol negotiated by the connection
+func websocketCodec(conn *websocket.Conn, endpoint *Endpoint) (func(interface{}) ([]byte, error), func(io.Reader) (interface{}, error), int) {
+	if endpoint.Encoding == "msgpack" || (endpoint.Encoding == "" && conn.Subprotocol() == MsgpackSubprotocol) {
+		return rotonde.ToMsgpack, rotonde.FromMsgpack, websocket.BinaryMessage
+	}
+	return rotonde.ToJSON, rotonde.FromJSON, websocket.TextMessage
+}
+
+// authenticateWebsocket looks for the token in the request, or waits for the
+// first packet, which has to be an auth packet, returns the identity of the client
+func authenticateWebsocket(conn *websocket.Conn, r *http.Request, authenticator *Authenticator, endpoint *Endpoint) (string, error) {
+	if token := tokenFromRequest(r); token != "" {
+		return authenticator.Authenticate(token)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(AuthTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	_, decode, frameType := websocketCodec(conn, endpoint)
+	messageType, reader, err := conn.NextReader()
+	if err != nil {
+		return "", err
+	}
+	if messageType != frameType {
+		return "", fmt.Errorf("Expected an auth packet")
+	}
+	packet, err := decode(reader)
+	if err != nil {
+		return "", err
+	}
+	auth, ok := packet.(rotonde.Auth)
+	if ok == false {
+		return "", fmt.Errorf("Expected an auth packet")
+	}
+	return authenticator.Authenticate(auth.Token)
+}
+
+func startWebsocketConnection(conn *websocket.Conn, d *Dispatcher, identity string, version int, federated bool, options WebsocketOptions, endpoint *Endpoint) {
+	encode, decode, frameType := websocketCodec(conn, endpoint)
+	log.Infof("Websocket using subprotocol \"%s\"", conn.Subprotocol())
+
 	c := NewConnection()
+	c.Identity = identity
+	c.ForwardSubscriptions = federated
+	// clients that didn't negotiate speak the version 1, they can still
+	// negotiate with a hello packet
+	c.Version = rotonde.MinProtocolVersion
+	if version != 0 {
+		// answers the version query parameter, before the definitions
+		c.Version = version
+		c.InChan <- rotonde.Hello{Version: version}
+	}
+	// c.Version belongs to the dispatcher once the connection is added, the
+	// writer keeps track of the hello packets going through instead
+	version = c.Version
 	d.AddConnection(c)
 	defer c.Close()
 
@@ -49,17 +279,48 @@ func startWebsocketConnection(conn *websocket.Conn, d *Dispatcher) {
 	go func() {
 		defer wg.Done()
 
+		var pings <-chan time.Time // nil when pings are disabled, never fires
+		if options.PingInterval > 0 {
+			ticker := time.NewTicker(options.PingInterval)
+			defer ticker.Stop()
+			pings = ticker.C
+		}
+
 		for {
 			select {
-			case dispatcherPacket := <-c.InChan:
-				jsonPacket, err := rotonde.ToJSON(dispatcherPacket)
-				if err != nil {
-					log.Warning(err)
-				}
-				if err := conn.WriteMessage(websocket.TextMessage, jsonPacket); err != nil {
+			case <-pings:
+				if err := conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(options.PingInterval)); err != nil {
 					log.Warning(err)
+					conn.Close()
 					return
 				}
+			case dispatcherPacket := <-c.InChan:
+				packets := []interface{}{dispatcherPacket}
+				if options.BatchSize > 1 && version >= rotonde.BatchVersion {
+					packets = coalesce(dispatcherPacket, c.InChan, options.BatchSize)
+				}
+				for _, packet := range packets {
+					// the version changes when the dispatcher answers a hello packet
+					if hello, ok := packet.(rotonde.Hello); ok {
+						version = hello.Version
+					}
+					encodedPacket, err := encode(packet)
+					if err != nil {
+						log.Warning(err)
+						continue
+					}
+					if options.WriteTimeout > 0 {
+						conn.SetWriteDeadline(time.Now().Add(options.WriteTimeout))
+					}
+					// no-op when compression was not negotiated
+					conn.EnableWriteCompression(options.EnableCompression && len(encodedPacket) >= options.CompressionThreshold)
+					if err := conn.WriteMessage(frameType, encodedPacket); err != nil {
+						log.Warning(err)
+						// unblocks the reader
+						conn.Close()
+						return
+					}
+				}
 			case <-errChan:
 				log.Warning("Got error from errChan")
 				return
@@ -71,17 +332,46 @@ func startWebsocketConnection(conn *websocket.Conn, d *Dispatcher) {
 	go func() {
 		defer wg.Done()
 
+		// the read deadline is the closest of the idle deadline, pushed by
+		// each message, and the pong deadline, pushed by each pong (and
+		// message). Both are only accessed from this goroutine, pong
+		// handlers are called from NextReader.
+		var idleDeadline time.Time
+		setReadDeadline := func() {
+			var deadline time.Time
+			if options.PingInterval > 0 {
+				deadline = time.Now().Add(options.PongTimeout)
+			}
+			if idleDeadline.IsZero() == false && (deadline.IsZero() || idleDeadline.Before(deadline)) {
+				deadline = idleDeadline
+			}
+			conn.SetReadDeadline(deadline)
+		}
+		conn.SetPongHandler(func(string) error {
+			setReadDeadline()
+			return nil
+		})
+
 		for {
+			if options.IdleTimeout > 0 {
+				idleDeadline = time.Now().Add(options.IdleTimeout)
+			}
+			setReadDeadline()
 			messageType, reader, err := conn.NextReader()
 			if err != nil {
 				log.Warning(err)
 				errChan <- err
 				return
 			}
-			if messageType == websocket.TextMessage {
-				dispatcherPacket, err := rotonde.FromJSON(reader)
+			if messageType == frameType {
+				dispatcherPacket, err := decode(reader)
 				if err != nil {
 					log.Warning(err)
+					continue
+				}
+				if endpoint.allows(dispatcherPacket) == false {
+					log.Warningf("Packet %T not allowed on this endpoint", dispatcherPacket)
+					continue
 				}
 				c.OutChan <- dispatcherPacket
 			}
@@ -98,3 +388,40 @@ func startWebsocketConnection(conn *websocket.Conn, d *Dispatcher) {
 	}
 	log.Info("Websocket connection end")
 }
+
+// coalesce gathers the events and actions queued in inChan after packet in a
+// batch of at most batchSize packets, the packet that stopped the batch, if
+// any, is returned after it so the order is kept
+func coalesce(packet interface{}, inChan chan interface{}, batchSize int) []interface{} {
+	if rotonde.IsBatchable(packet) == false {
+		return []interface{}{packet}
+	}
+
+	batch := rotonde.Batch{Packets: []interface{}{packet}}
+	var next interface{}
+gather:
+	for len(batch.Packets) < batchSize {
+		select {
+		case queued, ok := <-inChan:
+			if ok == false {
+				break gather
+			}
+			if rotonde.IsBatchable(queued) == false {
+				next = queued
+				break gather
+			}
+			batch.Packets = append(batch.Packets, queued)
+		default:
+			break gather
+		}
+	}
+
+	packets := []interface{}{batch}
+	if len(batch.Packets) == 1 {
+		packets = []interface{}{packet}
+	}
+	if next != nil {
+		packets = append(packets, next)
+	}
+	return packets
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HackerLoop/rotonde/shared"
+	"github.com/gorilla/websocket"
+)
+
+// testCertificate generates a self-signed certificate for 127.0.0.1, usable
+// as server certificate, client certificate and CA. The PEM files are
+// written in dir.
+func testCertificate(t *testing.T, dir string) (certificate tls.Certificate, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rotonde test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err = tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, certFile, keyFile
+}
+
+// startTLSServer serves the default websocket endpoint over TLS, configured like StartWebsocket does
+func startTLSServer(t *testing.T, options WebsocketOptions) *httptest.Server {
+	tlsConfig, err := newTLSConfig(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := tls.LoadX509KeyPair(options.TLSCert, options.TLSKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig.Certificates = []tls.Certificate{certificate}
+
+	d := NewDispatcher()
+	go d.Start()
+	server := httptest.NewUnstartedServer(websocketHandler(d, options, DefaultEndpoints(nil)["/"]))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	return server
+}
+
+func tlsDialer(certificate tls.Certificate, clientCertificates []tls.Certificate) *websocket.Dialer {
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate.Leaf)
+	return &websocket.Dialer{
+		TLSClientConfig:  &tls.Config{RootCAs: roots, Certificates: clientCertificates},
+		HandshakeTimeout: 5 * time.Second,
+	}
+}
+
+func TestWebsocketTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotonde-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certificate, certFile, keyFile := testCertificate(t, dir)
+	certificate.Leaf, _ = x509.ParseCertificate(certificate.Certificate[0])
+
+	server := startTLSServer(t, WebsocketOptions{TLSCert: certFile, TLSKey: keyFile})
+	defer server.Close()
+	url := "wss" + strings.TrimPrefix(server.URL, "https")
+
+	conn, _, err := tlsDialer(certificate, nil).Dial(url, nil)
+	if err != nil {
+		t.Fatal("wss connection failed: ", err)
+	}
+	// the definitions of the presence events are sent on connection
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Error("No packet received over wss: ", err)
+	}
+	conn.Close()
+
+	if _, _, err := websocket.DefaultDialer.Dial(url, nil); err == nil {
+		t.Error("Expected the self-signed certificate to be rejected by a client that doesn't trust it")
+	}
+}
+
+func TestWebsocketTLSClientCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotonde-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certificate, certFile, keyFile := testCertificate(t, dir)
+	certificate.Leaf, _ = x509.ParseCertificate(certificate.Certificate[0])
+
+	server := startTLSServer(t, WebsocketOptions{TLSCert: certFile, TLSKey: keyFile, TLSClientCA: certFile})
+	defer server.Close()
+	url := "wss" + strings.TrimPrefix(server.URL, "https")
+
+	if conn, _, err := tlsDialer(certificate, nil).Dial(url, nil); err == nil {
+		// with TLS 1.3 the client certificate is checked after the handshake,
+		// the server closes the connection
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := conn.ReadMessage(); err == nil {
+			t.Error("Expected a client without certificate to be rejected")
+		}
+		conn.Close()
+	}
+
+	conn, _, err := tlsDialer(certificate, []tls.Certificate{certificate}).Dial(url, nil)
+	if err != nil {
+		t.Fatal("Client with a certificate signed by the CA rejected: ", err)
+	}
+	conn.Close()
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotonde-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, certFile, keyFile := testCertificate(t, dir)
+
+	if _, err := newTLSConfig(WebsocketOptions{TLSCert: certFile}); err == nil {
+		t.Error("Expected an error when -tls-key is missing")
+	}
+	if _, err := newTLSConfig(WebsocketOptions{TLSCert: certFile, TLSKey: keyFile, TLSClientCA: keyFile}); err == nil {
+		t.Error("Expected an error when the client CA file has no certificate")
+	}
+	tlsConfig, err := newTLSConfig(WebsocketOptions{TLSCert: certFile, TLSKey: keyFile, TLSClientCA: certFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert || tlsConfig.ClientCAs == nil {
+		t.Error("Expected client certificates to be required and verified")
+	}
+}
+
+// startWebsocketServer serves the default websocket endpoint without TLS, and returns its ws:// URL
+func startWebsocketServer(t *testing.T, options WebsocketOptions) (*httptest.Server, string) {
+	d := NewDispatcher()
+	go d.Start()
+	server := httptest.NewServer(websocketHandler(d, options, DefaultEndpoints(nil)["/"]))
+	return server, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestWebsocketOrigin(t *testing.T) {
+	server, url := startWebsocketServer(t, WebsocketOptions{AllowedOrigins: []string{"http://dashboard.local"}})
+	defer server.Close()
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"http://dashboard.local", true},
+		{"", true}, // non-browser clients
+		{"http://evil.example", false},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.origin != "" {
+			header.Set("Origin", test.origin)
+		}
+		conn, response, err := websocket.DefaultDialer.Dial(url, header)
+		if test.allowed {
+			if err != nil {
+				t.Errorf("Origin %q: expected the connection to be accepted, got %v", test.origin, err)
+				continue
+			}
+			conn.Close()
+		} else if err == nil || response == nil || response.StatusCode != http.StatusForbidden {
+			t.Errorf("Origin %q: expected 403, got %v", test.origin, err)
+		}
+	}
+}
+
+func TestWebsocketMaxMessageSize(t *testing.T) {
+	server, url := startWebsocketServer(t, WebsocketOptions{MaxMessageSize: 1024})
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	event, _ := rotonde.ToJSON(rotonde.Event{Identifier: "BLOB", Data: rotonde.Object{"blob": strings.Repeat("a", 2048)}})
+	if err := conn.WriteMessage(websocket.TextMessage, event); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err = conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	if websocket.IsCloseError(err, websocket.CloseMessageTooBig) == false {
+		t.Errorf("Expected the connection to be closed with 1009, got %v", err)
+	}
+}
+
+func TestWebsocketIdleTimeout(t *testing.T) {
+	server, url := startWebsocketServer(t, WebsocketOptions{IdleTimeout: 100 * time.Millisecond})
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the peer sends nothing, it is disconnected
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err = conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Error("Expected the idle connection to be closed by the server")
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	event := func(i int) rotonde.Event { return rotonde.Event{Identifier: fmt.Sprint("EVENT_", i)} }
+	definition := rotonde.Definition{Identifier: "LED", Type: "action"}
+
+	tests := []struct {
+		name     string
+		first    interface{}
+		queued   []interface{}
+		expected []interface{}
+		left     int // packets left in the chan
+	}{
+		{"alone", event(0), nil, []interface{}{event(0)}, 0},
+		{"not batchable", definition, []interface{}{event(1)}, []interface{}{definition}, 1},
+		{"batch", event(0), []interface{}{event(1), event(2)}, []interface{}{rotonde.Batch{Packets: []interface{}{event(0), event(1), event(2)}}}, 0},
+		{"batch size", event(0), []interface{}{event(1), event(2), event(3)}, []interface{}{rotonde.Batch{Packets: []interface{}{event(0), event(1), event(2)}}}, 1},
+		{"not batchable after", event(0), []interface{}{event(1), definition, event(2)}, []interface{}{rotonde.Batch{Packets: []interface{}{event(0), event(1)}}, definition}, 1},
+		{"not batchable next", event(0), []interface{}{definition}, []interface{}{event(0), definition}, 0},
+	}
+	for _, test := range tests {
+		inChan := make(chan interface{}, 10)
+		for _, packet := range test.queued {
+			inChan <- packet
+		}
+		packets := coalesce(test.first, inChan, 3)
+		if reflect.DeepEqual(packets, test.expected) == false {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, packets)
+		}
+		if len(inChan) != test.left {
+			t.Errorf("%s: expected %d packets left, got %d", test.name, test.left, len(inChan))
+		}
+	}
+}